@@ -0,0 +1,118 @@
+// cmd/migrate/main.go
+package main
+
+import (
+	"chat-system/internal/db"
+	"chat-system/internal/db/migrations"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `Usage: migrate <command> [flags]
+
+Commands:
+  up                     Apply every pending migration
+  down [-steps N]        Roll back the last N applied migrations (default 1)
+  status                 List migrations and when they were applied
+  create [-dir D] <name> Write an empty up/down pair for a new migration
+`
+
+// The migrate command manages the versioned schema migrations outside of
+// the server, e.g. as a deploy step before new instances start.
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "up":
+		runUp()
+	case "down":
+		runDown(args)
+	case "status":
+		runStatus()
+	case "create":
+		runCreate(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func newMigrator() *migrations.Migrator {
+	db.ConnectMySQL()
+
+	migrator, err := migrations.NewMigrator(db.DB, migrations.Files)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	return migrator
+}
+
+func runUp() {
+	// Same path as server startup, so databases created before versioned
+	// migrations are adopted before anything is applied
+	db.ConnectMySQL()
+	migrations.RunMigrations(db.GormDB)
+}
+
+func runDown(args []string) {
+	fs := flag.NewFlagSet("down", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "Number of migrations to roll back")
+	fs.Parse(args)
+
+	if *steps < 1 {
+		log.Fatal("-steps must be at least 1")
+	}
+
+	rolledBack, err := newMigrator().Down(context.Background(), *steps)
+	for _, m := range rolledBack {
+		fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatalf("Rollback failed: %v", err)
+	}
+	if len(rolledBack) == 0 {
+		fmt.Println("Nothing to roll back")
+	}
+}
+
+func runStatus() {
+	statuses, err := newMigrator().Status(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to read migration status: %v", err)
+	}
+
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-40s  %s\n", s.Version, s.Name, applied)
+	}
+}
+
+func runCreate(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := fs.String("dir", "internal/db/migrations/sql", "Directory holding the migration files")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: migrate create [-dir D] <name>")
+	}
+
+	upPath, downPath, err := migrations.Create(*dir, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to create migration: %v", err)
+	}
+	fmt.Println("Created", upPath)
+	fmt.Println("Created", downPath)
+}
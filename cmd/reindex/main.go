@@ -0,0 +1,49 @@
+// cmd/reindex/main.go
+package main
+
+import (
+	"chat-system/internal/db"
+	"chat-system/internal/jobs"
+	"chat-system/internal/reindex"
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// The reindex command rebuilds the message search index from MySQL while
+// the service keeps running. Interrupting it leaves a checkpoint; running
+// it again resumes from there.
+func main() {
+	batchSize := flag.Int("batch", reindex.DefaultBatchSize, "Messages read and indexed per batch")
+	restart := flag.Bool("restart", false, "Discard the checkpoint of an interrupted run and start over")
+	keepOld := flag.Bool("keep-old", false, "Keep the previous index after switching the alias")
+	flag.Parse()
+
+	db.Connect()
+
+	reindexer := reindex.NewReindexer(db.GormDB, db.ES, db.Redis, jobs.NewStore(db.Redis))
+	opts := reindex.Options{BatchSize: *batchSize, Restart: *restart, KeepOld: *keepOld}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	claim, err := reindexer.Start(ctx, opts)
+	if errors.Is(err, reindex.ErrRunning) {
+		log.Fatal("Another reindex is running; wait for it or check its job")
+	}
+	if err != nil {
+		log.Fatalf("Failed to start reindex: %v", err)
+	}
+	log.Printf("Reindexing into %s, job %s (GET /jobs/%s for progress)", claim.Job.Target, claim.Job.ID, claim.Job.ID)
+
+	if err := reindexer.Run(ctx, claim, opts); err != nil {
+		if ctx.Err() != nil {
+			log.Fatal("Reindex interrupted; run again to resume from the checkpoint")
+		}
+		log.Fatalf("Reindex failed: %v", err)
+	}
+}
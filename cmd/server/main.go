@@ -10,13 +10,19 @@ package main
 import (
 	"chat-system/internal/api/handlers"
 	"chat-system/internal/db"
+	"chat-system/internal/jobs"
 	"chat-system/internal/logger"
 	"chat-system/internal/middleware"
+	"chat-system/internal/pkg/clientip"
 	"chat-system/internal/queue"
+	"chat-system/internal/ratelimit"
+	"chat-system/internal/reindex"
 	"chat-system/internal/service"
 	"chat-system/internal/worker"
 	"context"
+	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -30,32 +36,77 @@ import (
 )
 
 func main() {
+	host := flag.String("host", "0.0.0.0", "Address to listen on")
+	port := flag.String("port", "8080", "Port to listen on")
+	embeddedWorker := flag.Bool("embedded-worker", true, "Run the queue worker inside the server process")
+	flag.Parse()
+
+	// Without it nobody could create the first API keys, and a guessable
+	// default would hand them to anyone
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		log.Fatal("ADMIN_API_KEY must be set to a secret bootstrap key")
+	}
+
 	// Initialize Database and Services
 	db.Connect()
 
 	// Initialize Queue
 	messageQueue := queue.NewMessageQueue(db.Redis)
 
+	rateLimits, err := ratelimit.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load rate limits: %v", err)
+	}
+	ipResolver, err := clientip.ResolverFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load trusted proxies: %v", err)
+	}
+
 	// Initialize Services
-	appService := service.NewApplicationService(db.GormDB)
-	chatService := service.NewChatService(db.GormDB, db.Redis, messageQueue)
-	messageService := service.NewMessageService(db.GormDB, db.Redis, messageQueue, db.ES)
+	counterService := service.NewCounterService(db.GormDB, db.Redis)
+	tokenResolver := service.NewTokenResolver(db.GormDB, db.Redis)
+	appService := service.NewApplicationService(db.GormDB, db.Redis, tokenResolver, rateLimits.PlanNames())
+	chatResolver := service.NewChatResolver(db.GormDB, db.Redis, tokenResolver)
+	chatService := service.NewChatService(db.GormDB, db.Redis, messageQueue, counterService, tokenResolver, chatResolver)
+	deletionService := service.NewDeletionService(db.GormDB, messageQueue, jobs.NewStore(db.Redis), tokenResolver, chatResolver)
+	messageService := service.NewMessageService(db.GormDB, db.Redis, messageQueue, db.ES, chatResolver, counterService)
+	apiKeyService := service.NewAPIKeyService(db.GormDB, adminKey)
+
+	// Bring the Redis sequence counters up to date with MySQL before handing
+	// out numbers, in case Redis lost them
+	if result, err := counterService.Reconcile(context.Background()); err != nil {
+		logger.Error(context.Background(), "Counter reconciliation failed", err)
+	} else {
+		logger.Info(context.Background(), "Counters reconciled", result)
+	}
 
 	// Initialize Handlers
 	appHandler := handlers.NewApplicationHandler(appService)
 	chatHandler := handlers.NewChatHandler(chatService)
 	messageHandler := handlers.NewMessageHandler(messageService)
-
-	// Initialize Worker
-	worker := worker.NewWorker(messageQueue, db.ES)
+	deletionHandler := handlers.NewDeletionHandler(deletionService, appService)
+	adminHandler := handlers.NewAdminHandler(messageQueue, counterService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	reindexHandler := handlers.NewReindexHandler(
+		reindex.NewReindexer(db.GormDB, db.ES, db.Redis, jobs.NewStore(db.Redis)),
+		reindex.NewVerifier(db.GormDB, db.ES, db.Redis),
+	)
+
+	// Initialize Worker, unless it runs as a separate process (cmd/worker)
+	var w *worker.Worker
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	worker.Start(ctx)
+	if *embeddedWorker {
+		w = worker.NewWorker(messageQueue, db.ES, worker.ConfigFromEnv())
+		w.Start(ctx)
+	}
 
 	// Initialize middlewares
-	rateLimiter := middleware.NewRateLimiter(100, 200)
+	rateLimiter := middleware.NewRateLimiter(ratelimit.NewLimiter(db.Redis), rateLimits, tokenResolver, appService)
 
 	router := mux.NewRouter()
+	router.Use(middleware.ClientIP(ipResolver))
 	router.Use(middleware.RequestLogger)
 	router.Use(middleware.ErrorHandler)
 	router.Use(rateLimiter.RateLimit)
@@ -65,23 +116,69 @@ func main() {
 		httpSwagger.URL("/swagger/doc.json"),
 	))
 
-	// Application routes
-	router.HandleFunc("/applications", appHandler.Create).Methods("POST")
-	router.HandleFunc("/applications", appHandler.GetAll).Methods("GET")
-	router.HandleFunc("/applications/{token}", appHandler.Update).Methods("PUT")
-	router.HandleFunc("/applications/{token}/chats", appHandler.GetChats).Methods("GET")
+	// Application management needs an admin API key with the right scope
+	readApps := router.NewRoute().Subrouter()
+	readApps.Use(middleware.RequireScope(apiKeyService, service.ScopeApplicationsRead))
+	readApps.HandleFunc("/applications", appHandler.GetAll).Methods("GET")
+
+	manageApps := router.NewRoute().Subrouter()
+	manageApps.Use(middleware.RequireScope(apiKeyService, service.ScopeApplicationsWrite))
+	manageApps.HandleFunc("/applications", appHandler.Create).Methods("POST").Name(ratelimit.RouteApplicationsCreate)
+	manageApps.HandleFunc("/applications/{token}", appHandler.Update).Methods("PUT")
+	manageApps.HandleFunc("/applications/{token}", deletionHandler.DeleteApplication).Methods("DELETE")
+	manageApps.HandleFunc("/applications/{token}/token/rotate", appHandler.RotateToken).Methods("POST")
+	manageApps.HandleFunc("/applications/{token}/token/revoke", appHandler.RevokeTokens).Methods("POST")
+	manageApps.HandleFunc("/applications/{token}/plan", appHandler.SetPlan).Methods("PUT")
+
+	// Everything inside an application is authorized by its token
+	appRoutes := router.NewRoute().Subrouter()
+	appRoutes.Use(middleware.RequireAppToken(tokenResolver))
+	appRoutes.HandleFunc("/applications/{token}", appHandler.Get).Methods("GET")
+	appRoutes.HandleFunc("/applications/{token}/chats", appHandler.GetChats).Methods("GET")
 
 	// Chat routes
-	router.HandleFunc("/chats/{token}", chatHandler.Create).Methods("POST")
+	appRoutes.HandleFunc("/chats/{token}", chatHandler.Create).Methods("POST").Name(ratelimit.RouteChatsCreate)
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}", chatHandler.Get).Methods("GET")
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}", deletionHandler.DeleteChat).Methods("DELETE")
+	appRoutes.HandleFunc("/applications/{token}/jobs/{id}", deletionHandler.GetApplicationJob).Methods("GET")
 
 	// Message routes
-	router.HandleFunc("/messages/{chatNumber}", messageHandler.Create).Methods("POST")
-	router.HandleFunc("/applications/{token}/chats/{chatNumber}/messages", messageHandler.GetMessages).Methods("GET")
-	router.HandleFunc("/chats/{chatNumber}/messages/search", messageHandler.Search).Methods("GET")
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages", messageHandler.Create).Methods("POST").Name(ratelimit.RouteMessagesCreate)
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages", messageHandler.GetMessages).Methods("GET")
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages/search", messageHandler.Search).Methods("GET").Name(ratelimit.RouteMessagesSearch)
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages/{messageNumber:[0-9]+}", messageHandler.Get).Methods("GET")
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages/{messageNumber:[0-9]+}", messageHandler.Update).Methods("PUT")
+	appRoutes.HandleFunc("/applications/{token}/chats/{chatNumber}/messages/{messageNumber:[0-9]+}", messageHandler.Delete).Methods("DELETE")
+
+	// Jobs started with an admin key, such as application deletions and
+	// reindexes; application jobs are also under /applications/{token}/jobs
+	jobRoutes := router.NewRoute().Subrouter()
+	jobRoutes.Use(middleware.RequireScope(apiKeyService, service.ScopeApplicationsWrite, service.ScopeAdmin))
+	jobRoutes.HandleFunc("/jobs/{id}", deletionHandler.GetJob).Methods("GET")
+
+	// Creating API keys is also open to the bootstrap key, so the first
+	// ones can be made
+	createKeys := router.NewRoute().Subrouter()
+	createKeys.Use(middleware.RequireScope(apiKeyService, service.ScopeAdmin, service.ScopeBootstrap))
+	createKeys.HandleFunc("/admin/api-keys", apiKeyHandler.Create).Methods("POST")
+
+	// Admin routes
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireScope(apiKeyService, service.ScopeAdmin))
+	admin.HandleFunc("/api-keys", apiKeyHandler.List).Methods("GET")
+	admin.HandleFunc("/api-keys/{id:[0-9]+}", apiKeyHandler.Revoke).Methods("DELETE")
+	admin.HandleFunc("/queue/dead", adminHandler.ListDeadJobs).Methods("GET")
+	admin.HandleFunc("/queue/dead", adminHandler.PurgeDeadJobs).Methods("DELETE")
+	admin.HandleFunc("/queue/dead/replay", adminHandler.ReplayAllDeadJobs).Methods("POST")
+	admin.HandleFunc("/queue/dead/{id}/replay", adminHandler.ReplayDeadJob).Methods("POST")
+	admin.HandleFunc("/queue/dead/{id}", adminHandler.DeleteDeadJob).Methods("DELETE")
+	admin.HandleFunc("/counters/reconcile", adminHandler.ReconcileCounters).Methods("POST")
+	admin.HandleFunc("/search/reindex", reindexHandler.Reindex).Methods("POST")
+	admin.HandleFunc("/search/consistency", reindexHandler.Consistency).Methods("GET")
 
 	// Create server with timeouts
 	srv := &http.Server{
-		Addr:         "0.0.0.0:8080",
+		Addr:         net.JoinHostPort(*host, *port),
 		Handler:      router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -111,5 +208,12 @@ func main() {
 		logger.Error(context.Background(), "Server forced to shutdown", err)
 	}
 
+	if w != nil {
+		cancel()
+		if err := w.Shutdown(shutdownCtx); err != nil {
+			logger.Error(context.Background(), "Worker forced to shutdown", err)
+		}
+	}
+
 	logger.Info(context.Background(), "Server stopped")
 }
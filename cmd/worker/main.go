@@ -0,0 +1,56 @@
+// cmd/worker/main.go
+package main
+
+import (
+	"chat-system/internal/db"
+	"chat-system/internal/logger"
+	"chat-system/internal/queue"
+	"chat-system/internal/worker"
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The worker runs the queue consumers and counter sync without the HTTP
+// server, so persistence can be scaled and deployed on its own.
+func main() {
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait for in-flight jobs on shutdown")
+	flag.Parse()
+
+	// Initialize Database and Services
+	db.Connect()
+
+	// Initialize Queue
+	messageQueue := queue.NewMessageQueue(db.Redis)
+
+	cfg := worker.ConfigFromEnv()
+	w := worker.NewWorker(messageQueue, db.ES, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Start(ctx)
+
+	logger.Info(context.Background(), "Worker started", map[string]interface{}{"concurrency": cfg.Concurrency})
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	logger.Info(context.Background(), "Worker is shutting down...")
+
+	// Stop taking new jobs and let consumers finish the batch in hand
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer shutdownCancel()
+
+	if err := w.Shutdown(shutdownCtx); err != nil {
+		logger.Error(context.Background(), "Worker forced to shutdown", err)
+	}
+
+	logger.Info(context.Background(), "Worker stopped")
+}
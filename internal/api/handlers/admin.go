@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/queue"
+	"chat-system/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// @title Admin API
+// @version 1.0
+// @description Admin handler exposes operational endpoints for on-call
+
+type AdminHandler struct {
+	queue    *queue.MessageQueue
+	counters *service.CounterService
+}
+
+func NewAdminHandler(queue *queue.MessageQueue, counters *service.CounterService) *AdminHandler {
+	return &AdminHandler{queue: queue, counters: counters}
+}
+
+// @Summary List dead-lettered jobs
+// @Description Lists queue jobs that exhausted their retries, most recent first
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(50)
+// @Success 200 {object} handlers.DeadJobListResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/queue/dead [get]
+func (h *AdminHandler) ListDeadJobs(w http.ResponseWriter, r *http.Request) {
+	page, limit := 1, 50 // default values
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		p, err := strconv.Atoi(pageStr)
+		if err != nil || p < 1 {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid page")
+			return
+		}
+		page = p
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 500 {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = l
+	}
+
+	jobs, total, err := h.queue.ListDead(r.Context(), int64((page-1)*limit), int64(limit))
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := DeadJobListResponse{
+		Jobs:  make([]DeadJobResponse, len(jobs)),
+		Total: total,
+	}
+	for i, job := range jobs {
+		response.Jobs[i] = DeadJobResponse{
+			ID:         job.ID,
+			Type:       job.Type,
+			Payload:    job.Payload,
+			Attempts:   job.Attempts,
+			LastError:  job.LastError,
+			EnqueuedAt: job.EnqueuedAt,
+			FailedAt:   job.FailedAt,
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, response)
+}
+
+// @Summary Replay a dead-lettered job
+// @Description Moves a dead-lettered job back onto the work queue with its attempts reset
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param id path string true "Job ID"
+// @Success 202 {object} handlers.DeadJobActionResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/queue/dead/{id}/replay [post]
+func (h *AdminHandler) ReplayDeadJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.queue.ReplayDead(r.Context(), id); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusAccepted, DeadJobActionResponse{Count: 1})
+}
+
+// @Summary Replay all dead-lettered jobs
+// @Description Moves every dead-lettered job back onto the work queue
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Success 202 {object} handlers.DeadJobActionResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/queue/dead/replay [post]
+func (h *AdminHandler) ReplayAllDeadJobs(w http.ResponseWriter, r *http.Request) {
+	count, err := h.queue.ReplayAllDead(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusAccepted, DeadJobActionResponse{Count: int64(count)})
+}
+
+// @Summary Delete a dead-lettered job
+// @Description Permanently discards a single dead-lettered job
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param id path string true "Job ID"
+// @Success 200 {object} handlers.DeadJobActionResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/queue/dead/{id} [delete]
+func (h *AdminHandler) DeleteDeadJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.queue.DeleteDead(r.Context(), id); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, DeadJobActionResponse{Count: 1})
+}
+
+// @Summary Purge dead-lettered jobs
+// @Description Permanently discards every dead-lettered job
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Success 200 {object} handlers.DeadJobActionResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/queue/dead [delete]
+func (h *AdminHandler) PurgeDeadJobs(w http.ResponseWriter, r *http.Request) {
+	count, err := h.queue.PurgeDead(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, DeadJobActionResponse{Count: count})
+}
+
+// @Summary Reconcile sequence counters
+// @Description Raises the Redis chat and message number counters to at least the highest numbers stored in MySQL
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Success 200 {object} service.ReconcileResult
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/counters/reconcile [post]
+func (h *AdminHandler) ReconcileCounters(w http.ResponseWriter, r *http.Request) {
+	result, err := h.counters.Reconcile(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+func writeQueueError(w http.ResponseWriter, err error) {
+	if errors.Is(err, queue.ErrJobNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+}
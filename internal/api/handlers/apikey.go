@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"chat-system/internal/db/models"
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/pkg/validation"
+	"chat-system/internal/service"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// @title API Key API
+// @version 1.0
+// @description API key handler manages the admin API keys
+
+type APIKeyHandler struct {
+	service *service.APIKeyService
+}
+
+func NewAPIKeyHandler(service *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: service}
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+func newAPIKeyResponse(key *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.ScopeList(),
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// @Summary Create an API key
+// @Description Issues an admin API key with the given scopes (applications:read, applications:write, admin). The key is only returned in this response. The bootstrap key from ADMIN_API_KEY may call this until a key with the admin scope exists.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param key body createAPIKeyRequest true "API key creation request"
+// @Success 201 {object} handlers.APIKeyResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors := validation.ValidateStruct(req); len(errors) > 0 {
+		httputil.WriteValidationErrors(w, errors)
+		return
+	}
+
+	key, plain, err := h.service.CreateKey(r.Context(), req.Name, req.Scopes)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	response := newAPIKeyResponse(key)
+	response.Key = plain
+	httputil.WriteJSON(w, http.StatusCreated, response)
+}
+
+// @Summary List API keys
+// @Description Lists all admin API keys, revoked ones included, without the keys themselves
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Success 200 {array} handlers.APIKeyResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.ListKeys(r.Context())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	response := make([]APIKeyResponse, len(keys))
+	for i := range keys {
+		response[i] = newAPIKeyResponse(&keys[i])
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, response)
+}
+
+// @Summary Revoke an API key
+// @Description Disables an admin API key immediately
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param id path int true "API key ID"
+// @Success 200 {object} handlers.APIKeyResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	key, err := h.service.RevokeKey(r.Context(), uint(id))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, newAPIKeyResponse(key))
+}
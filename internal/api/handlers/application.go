@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"chat-system/internal/db/models"
 	"chat-system/internal/pkg/httputil"
 	"chat-system/internal/pkg/validation"
 	"chat-system/internal/service"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -38,9 +42,12 @@ type createApplicationRequest struct {
 // @Tags Applications
 // @Accept json
 // @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
 // @Param application body createApplicationRequest true "Application creation request"
 // @Success 201 {object} handlers.ApplicationResponse
 // @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications [post]
 func (h *ApplicationHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -75,50 +82,80 @@ func (h *ApplicationHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Get all applications
-// @Description Retrieves all applications with pagination
+// @Description Retrieves all applications with pagination. Tokens are not included.
 // @Tags Applications
 // @Accept json
 // @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
 // @Param page query int false "Page number" default(1)
-// @Param limit query int false "Items per page" default(10)
-// @Success 200 {array} handlers.ApplicationListResponse
+// @Param limit query int false "Items per page (at most 100)" default(10)
+// @Success 200 {object} httputil.Page{data=[]handlers.ApplicationSummaryResponse}
+// @Header 200 {string} Link "Links to the first, previous, next and last pages"
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications [get]
 func (h *ApplicationHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	// Get pagination parameters from query
-	pageStr := r.URL.Query().Get("page")
-	limitStr := r.URL.Query().Get("limit")
-	page, limit := 1, 10 // default values
-	if pageStr != "" {
-		page, _ = strconv.Atoi(pageStr) // handle error appropriately in production
-	}
-	if limitStr != "" {
-		limit, _ = strconv.Atoi(limitStr) // handle error appropriately in production
+	p, err := httputil.ParsePagination(r)
+	if err != nil || p.CursorMode {
+		message := "Cursor mode is not supported for applications"
+		if err != nil {
+			message = err.Error()
+		}
+		httputil.WriteError(w, http.StatusBadRequest, message)
+		return
 	}
 
-	apps, err := h.service.GetAllApplications(r.Context(), page, limit)
+	apps, total, err := h.service.GetAllApplications(r.Context(), p.Page, p.Limit)
 
 	if err != nil {
 		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	response := make([]struct {
-		Token string `json:"token"`
-		Name  string `json:"name"`
-	}, len(apps))
-
+	response := make(ApplicationListResponse, len(apps))
 	for i, app := range apps {
-		response[i] = struct {
-			Token string `json:"token"`
-			Name  string `json:"name"`
-		}{
-			Token: app.Token,
-			Name:  app.Name,
+		response[i] = ApplicationSummaryResponse{
+			ID:          app.ID,
+			Name:        app.Name,
+			TokenPrefix: app.Token[:min(len(app.Token), 8)],
+			ChatsCount:  app.ChatsCount,
+			CreatedAt:   app.CreatedAt,
 		}
 	}
 
-	httputil.WriteJSON(w, http.StatusOK, response)
+	httputil.WritePage(w, r, p, response, total)
+}
+
+// @Summary Get application
+// @Description Retrieves a single application by token
+// @Tags Applications
+// @Produce json
+// @Param token path string true "Application Token"
+// @Success 200 {object} handlers.ApplicationDetailResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token} [get]
+func (h *ApplicationHandler) Get(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	app, err := h.service.GetApplicationByToken(r.Context(), token)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ApplicationDetailResponse{
+		Token:      app.Token,
+		Name:       app.Name,
+		Plan:       app.Plan,
+		ChatsCount: app.ChatsCount,
+		CreatedAt:  app.CreatedAt,
+		UpdatedAt:  app.UpdatedAt,
+	})
 }
 
 // @Summary Update application
@@ -126,15 +163,22 @@ func (h *ApplicationHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 // @Tags Applications
 // @Accept json
 // @Produce json
-// @Param token path string true "Application Token"
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param token path string true "Application token, or its ID from the application list"
 // @Param application body createApplicationRequest true "Application update request"
 // @Success 200 {object} handlers.ApplicationResponse
 // @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications/{token} [put]
 func (h *ApplicationHandler) Update(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	token := vars["token"]
+	token, err := applicationToken(r, h.service)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
 	var req createApplicationRequest
 
@@ -151,7 +195,7 @@ func (h *ApplicationHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	app, err := h.service.UpdateApplication(r.Context(), token, req.Name)
 	if err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		writeServiceError(w, err)
 		return
 	}
 
@@ -166,15 +210,173 @@ func (h *ApplicationHandler) Update(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, http.StatusOK, response)
 }
 
+// defaultTokenGracePeriod is how long the old token keeps working after a
+// rotation when the request does not say.
+const defaultTokenGracePeriod = 24 * time.Hour
+
+type rotateTokenRequest struct {
+	GracePeriodSeconds *int64 `json:"grace_period_seconds"`
+}
+
+// @Summary Rotate application token
+// @Description Issues a new token for the application. The old token keeps working for the grace period (one day by default, at most 30 days, 0 to retire it at once) so clients can switch over.
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param token path string true "Application token, or its ID from the application list"
+// @Param rotation body rotateTokenRequest false "Rotation options"
+// @Success 200 {object} handlers.TokenResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/token/rotate [post]
+func (h *ApplicationHandler) RotateToken(w http.ResponseWriter, r *http.Request) {
+	token, err := applicationToken(r, h.service)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	var req rotateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	gracePeriod := defaultTokenGracePeriod
+	if req.GracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	app, expiresAt, err := h.service.RotateToken(r.Context(), token, gracePeriod)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, TokenResponse{
+		Token:                  app.Token,
+		PreviousTokenExpiresAt: expiresAt,
+	})
+}
+
+// @Summary Revoke application tokens
+// @Description Issues a new token for the application and immediately invalidates the current token and any still in their grace period.
+// @Tags Applications
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param token path string true "Application token, or its ID from the application list"
+// @Success 200 {object} handlers.TokenResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/token/revoke [post]
+func (h *ApplicationHandler) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	token, err := applicationToken(r, h.service)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	app, err := h.service.RevokeTokens(r.Context(), token)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, TokenResponse{Token: app.Token})
+}
+
+type setPlanRequest struct {
+	Plan string `json:"plan" validate:"required"`
+}
+
+// @Summary Change application plan
+// @Description Moves an application to another plan, which sets its rate limits and daily message quota
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param token path string true "Application token, or its ID from the application list"
+// @Param plan body setPlanRequest true "Plan change request"
+// @Success 200 {object} handlers.ApplicationDetailResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/plan [put]
+func (h *ApplicationHandler) SetPlan(w http.ResponseWriter, r *http.Request) {
+	token, err := applicationToken(r, h.service)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	var req setPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors := validation.ValidateStruct(req); len(errors) > 0 {
+		httputil.WriteValidationErrors(w, errors)
+		return
+	}
+
+	app, err := h.service.SetPlan(r.Context(), token, req.Plan)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ApplicationDetailResponse{
+		Token:      app.Token,
+		Name:       app.Name,
+		Plan:       app.Plan,
+		ChatsCount: app.ChatsCount,
+		CreatedAt:  app.CreatedAt,
+		UpdatedAt:  app.UpdatedAt,
+	})
+}
+
+// applicationToken returns the token of the application an admin route
+// names. The path holds either the token or the ID shown in the
+// application list; tokens are 64 hex digits and never parse as an ID.
+func applicationToken(r *http.Request, apps *service.ApplicationService) (string, error) {
+	ref := mux.Vars(r)["token"]
+
+	id, err := strconv.ParseUint(ref, 10, 0)
+	if err != nil {
+		return ref, nil
+	}
+	return apps.TokenByID(r.Context(), uint(id))
+}
+
+// chatCursor is where a cursor-mode chats page ended.
+type chatCursor struct {
+	After int `json:"a"`
+}
+
 // @Summary Get application chats
-// @Description Retrieves all chats for an application
+// @Description Retrieves the chats of an application ordered by chat number. Pass mode=cursor (or a cursor from a previous page) for cursor pagination instead of pages.
 // @Tags Applications
 // @Accept json
 // @Produce json
 // @Param token path string true "Application Token"
 // @Param page query int false "Page number" default(1)
-// @Param limit query int false "Items per page" default(10)
-// @Success 200 {array} handlers.ApplicationChatResponse
+// @Param limit query int false "Items per page (at most 100)" default(10)
+// @Param mode query string false "Set to cursor for cursor pagination"
+// @Param cursor query string false "Cursor from a previous page"
+// @Success 200 {object} httputil.Page{data=[]handlers.ApplicationChatResponse}
+// @Header 200 {string} Link "Links to neighbouring pages"
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications/{token}/chats [get]
 func (h *ApplicationHandler) GetChats(w http.ResponseWriter, r *http.Request) {
@@ -182,39 +384,54 @@ func (h *ApplicationHandler) GetChats(w http.ResponseWriter, r *http.Request) {
 	token := vars["token"]
 
 	// Get pagination parameters from query
-	pageStr := r.URL.Query().Get("page")
-	limitStr := r.URL.Query().Get("limit")
-	page, limit := 1, 10 // default values
-	if pageStr != "" {
-		page, _ = strconv.Atoi(pageStr) // handle error appropriately in production
+	p, err := httputil.ParsePagination(r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if limitStr != "" {
-		limit, _ = strconv.Atoi(limitStr) // handle error appropriately in production
+
+	var cursor chatCursor
+	if p.Cursor != "" {
+		if err := httputil.DecodeCursor(p.Cursor, &cursor); err != nil {
+			writeServiceError(w, err)
+			return
+		}
 	}
 
-	chats, err := h.service.GetChatsWithApplicationByToken(r.Context(), token, page, limit)
+	var chats []models.Chat
+	var total int64
+	if p.CursorMode {
+		// Fetch one extra chat to learn whether another page follows
+		chats, total, err = h.service.GetChatsAfter(r.Context(), token, cursor.After, p.Limit+1)
+	} else {
+		chats, total, err = h.service.GetChatsWithApplicationByToken(r.Context(), token, p.Page, p.Limit)
+	}
 
 	if err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		writeServiceError(w, err)
 		return
 	}
 
-	// Prepare the response
-	response := make([]struct {
-		ChatNumber int `json:"Chat Number"`
-		Messages   int `json:"Messages"`
-	}, len(chats))
+	var next string
+	if p.CursorMode && len(chats) > p.Limit {
+		chats = chats[:p.Limit]
+		next = httputil.EncodeCursor(chatCursor{After: chats[len(chats)-1].ChatNumber})
+	}
 
+	// Prepare the response
+	response := make([]ApplicationChatResponse, len(chats))
 	for i, chat := range chats {
-		response[i] = struct {
-			ChatNumber int `json:"Chat Number"`
-			Messages   int `json:"Messages"`
-		}{
+		response[i] = ApplicationChatResponse{
 			ChatNumber: chat.ChatNumber,
 			Messages:   chat.MessagesCount,
 		}
 	}
 
 	// Return the response
-	httputil.WriteJSON(w, http.StatusOK, response)
-}
\ No newline at end of file
+	if !p.CursorMode {
+		httputil.WritePage(w, r, p, response, total)
+		return
+	}
+
+	httputil.WriteCursorPage(w, r, p, response, total, next)
+}
@@ -2,9 +2,11 @@
 package handlers
 
 import (
+	"chat-system/internal/pkg/httputil"
 	"chat-system/internal/service"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -28,6 +30,8 @@ func NewChatHandler(service *service.ChatService) *ChatHandler {
 // @Produce json
 // @Param token path string true "Application Token"
 // @Success 200 {object} handlers.ChatResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications/{token}/chats [post]
 func (h *ChatHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +40,7 @@ func (h *ChatHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	chat, err := h.service.CreateChat(r.Context(), token)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -48,4 +52,39 @@ func (h *ChatHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+// @Summary Get chat
+// @Description Retrieves a single chat of an application by its number
+// @Tags Chats
+// @Produce json
+// @Param token path string true "Application Token"
+// @Param chatNumber path int true "Chat Number"
+// @Success 200 {object} handlers.ChatDetailResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/chats/{chatNumber} [get]
+func (h *ChatHandler) Get(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+	chatNumber, err := strconv.Atoi(vars["chatNumber"])
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
+		return
+	}
+
+	chat, err := h.service.GetChat(r.Context(), token, chatNumber)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ChatDetailResponse{
+		ChatNumber:    chat.ChatNumber,
+		MessagesCount: chat.MessagesCount,
+		CreatedAt:     chat.CreatedAt,
+		UpdatedAt:     chat.UpdatedAt,
+	})
+}
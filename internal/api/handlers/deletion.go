@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"chat-system/internal/jobs"
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// @title Deletion API
+// @version 1.0
+// @description Deletion handler removes applications and chats in the background
+
+type DeletionHandler struct {
+	service *service.DeletionService
+	apps    *service.ApplicationService
+}
+
+func NewDeletionHandler(service *service.DeletionService, apps *service.ApplicationService) *DeletionHandler {
+	return &DeletionHandler{service: service, apps: apps}
+}
+
+// @Summary Delete application
+// @Description Hides an application right away and removes its chats, messages, counters and search documents in the background. Follow the returned job to see when it is done.
+// @Tags Applications
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param token path string true "Application token, or its ID from the application list"
+// @Success 202 {object} handlers.JobResponse
+// @Header 202 {string} Location "URL of the job status"
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token} [delete]
+func (h *DeletionHandler) DeleteApplication(w http.ResponseWriter, r *http.Request) {
+	token, err := applicationToken(r, h.apps)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	job, err := h.service.DeleteApplication(r.Context(), token)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeAcceptedJob(w, job, "/jobs/"+job.ID)
+}
+
+// @Summary Delete chat
+// @Description Hides a chat right away and removes its messages, counters and search documents in the background. Follow the returned job to see when it is done.
+// @Tags Chats
+// @Produce json
+// @Param token path string true "Application Token"
+// @Param chatNumber path int true "Chat Number"
+// @Success 202 {object} handlers.JobResponse
+// @Header 202 {string} Location "URL of the job status"
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/chats/{chatNumber} [delete]
+func (h *DeletionHandler) DeleteChat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+	chatNumber, err := strconv.Atoi(vars["chatNumber"])
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
+		return
+	}
+
+	job, err := h.service.DeleteChat(r.Context(), token, chatNumber)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeAcceptedJob(w, job, "/applications/"+token+"/jobs/"+job.ID)
+}
+
+// @Summary Get job status
+// @Description Reports the progress of a background job, such as an application deletion or a reindex. Finished jobs are kept for a week.
+// @Tags Jobs
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key with the applications:write or admin scope"
+// @Param id path string true "Job ID"
+// @Success 200 {object} handlers.JobResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /jobs/{id} [get]
+func (h *DeletionHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.GetJob(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, newJobResponse(job))
+}
+
+// @Summary Get application job status
+// @Description Reports the progress of a background job of the application, such as a chat deletion. Finished jobs are kept for a week.
+// @Tags Jobs
+// @Produce json
+// @Param token path string true "Application Token"
+// @Param id path string true "Job ID"
+// @Success 200 {object} handlers.JobResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/jobs/{id} [get]
+func (h *DeletionHandler) GetApplicationJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	job, err := h.service.GetApplicationJob(r.Context(), vars["token"], vars["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, newJobResponse(job))
+}
+
+func writeAcceptedJob(w http.ResponseWriter, job *jobs.Job, location string) {
+	w.Header().Set("Location", location)
+	httputil.WriteJSON(w, http.StatusAccepted, newJobResponse(job))
+}
+
+func newJobResponse(job *jobs.Job) JobResponse {
+	return JobResponse{
+		ID:        job.ID,
+		Type:      job.Type,
+		Target:    job.Target,
+		Status:    job.Status,
+		Error:     job.Error,
+		Progress:  job.Counters,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
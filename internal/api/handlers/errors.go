@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	apperrors "chat-system/internal/errors"
+	"chat-system/internal/jobs"
+	"chat-system/internal/logger"
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/service"
+	"context"
+	"errors"
+	"net/http"
+)
+
+// writeServiceError maps errors returned by the services onto API errors so
+// that missing resources are reported as 404s rather than 500s.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var appErr *apperrors.AppError
+
+	switch {
+	case errors.Is(err, service.ErrApplicationNotFound):
+		appErr = apperrors.ErrNotFound("Application")
+	case errors.Is(err, service.ErrChatNotFound):
+		appErr = apperrors.ErrNotFound("Chat")
+	case errors.Is(err, service.ErrMessageNotFound):
+		appErr = apperrors.ErrNotFound("Message")
+	case errors.Is(err, service.ErrMessagePending):
+		w.Header().Set("Retry-After", "1")
+		appErr = apperrors.ErrConflict("Message is still being created, retry shortly")
+	case errors.Is(err, service.ErrAPIKeyNotFound):
+		appErr = apperrors.ErrNotFound("API key")
+	case errors.Is(err, jobs.ErrJobNotFound):
+		appErr = apperrors.ErrNotFound("Job")
+	case errors.Is(err, httputil.ErrInvalidCursor), errors.Is(err, service.ErrInvalidOrder),
+		errors.Is(err, service.ErrInvalidGracePeriod), errors.Is(err, service.ErrInvalidScope),
+		errors.Is(err, service.ErrUnknownPlan), errors.Is(err, service.ErrInvalidSearchMode),
+		errors.Is(err, service.ErrInvalidSearchSort), errors.Is(err, service.ErrInvalidSearchPage),
+		errors.Is(err, service.ErrInvalidSearchOrder):
+		appErr = apperrors.ErrBadRequest(err.Error())
+	case errors.Is(err, service.ErrChatPending):
+		// The chat exists as soon as the worker catches up
+		w.Header().Set("Retry-After", "1")
+		appErr = apperrors.ErrConflict("Chat is still being created, retry shortly")
+	default:
+		logger.Error(context.Background(), "Request failed", err)
+		appErr = apperrors.ErrInternalServer(err)
+	}
+
+	httputil.WriteError(w, appErr.Code, appErr.Message)
+}
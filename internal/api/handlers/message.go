@@ -33,31 +33,36 @@ func NewMessageHandler(service *service.MessageService) *MessageHandler {
 // @Tags Messages
 // @Accept json
 // @Produce json
+// @Param token path string true "Application Token"
 // @Param chatNumber path int true "Chat Number"
 // @Param message body createMessageRequest true "Message creation request"
 // @Success 200 {object} MessageResponse
 // @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
-// @Router /chats/{chatNumber}/messages [post]
+// @Router /applications/{token}/chats/{chatNumber}/messages [post]
 func (h *MessageHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req createMessageRequest
 
 	vars := mux.Vars(r)
+	token := vars["token"]
 	chatNumber, err := strconv.Atoi(vars["chatNumber"])
 
 	if err != nil {
-		http.Error(w, "Invalid chat number", http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
 		return
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Check if Body is empty after decoding
 	if req.Body == "" {
-		http.Error(w, "Body cannot be empty", http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, "Body cannot be empty")
 		return
 	}
 
@@ -67,9 +72,9 @@ func (h *MessageHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	message, err := h.service.CreateMessage(r.Context(), uint(chatNumber), req.Body)
+	message, err := h.service.CreateMessage(r.Context(), token, chatNumber, req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -84,14 +89,22 @@ func (h *MessageHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Get messages
-// @Description Retrieves all messages for a chat
+// @Description Retrieves a page of a chat's messages. Pass next_cursor from the previous page as cursor to continue.
 // @Tags Messages
 // @Accept json
 // @Produce json
 // @Param token path string true "Application Token"
 // @Param chatNumber path int true "Chat Number"
-// @Success 200 {array} MessageListResponse
+// @Param after query int false "Only messages with a higher number"
+// @Param before query int false "Only messages with a lower number"
+// @Param limit query int false "Messages per page" default(50)
+// @Param order query string false "asc (oldest first) or desc (newest first)" default(asc)
+// @Param cursor query string false "Cursor from a previous page"
+// @Success 200 {object} MessagePageResponse
 // @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 // @Router /applications/{token}/chats/{chatNumber}/messages [get]
 func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
@@ -99,26 +112,41 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	token := vars["token"] // Get the application token from the URL
 	chatNumber, err := strconv.Atoi(vars["chatNumber"])
 	if err != nil {
-		http.Error(w, "Invalid chat number", http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
 		return
 	}
 
-	messages, err := h.service.GetMessagesByChatNumberAndToken(r.Context(), token, uint(chatNumber))
+	params := r.URL.Query()
+	query := service.MessagePageQuery{
+		Limit:  50, // default value
+		Order:  params.Get("order"),
+		Cursor: params.Get("cursor"),
+	}
+	for name, target := range map[string]*int{"after": &query.After, "before": &query.Before, "limit": &query.Limit} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || (name == "limit" && n > 1000) {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid "+name)
+			return
+		}
+		*target = n
+	}
+
+	page, err := h.service.ListMessages(r.Context(), token, chatNumber, query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
-	response := make([]struct {
-		MessageNumber int    `json:"Message Number"`
-		Body          string `json:"body"`
-	}, len(messages))
-
-	for i, message := range messages {
-		response[i] = struct {
-			MessageNumber int    `json:"Message Number"`
-			Body          string `json:"body"`
-		}{
+	response := MessagePageResponse{
+		Messages:   make([]MessageResponse, len(page.Messages)),
+		NextCursor: page.NextCursor,
+	}
+	for i, message := range page.Messages {
+		response.Messages[i] = MessageResponse{
 			MessageNumber: message.MessageNumber,
 			Body:          message.Body,
 		}
@@ -127,56 +155,211 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, http.StatusOK, response)
 }
 
+// @Summary Get message
+// @Description Retrieves a single message of a chat by its number
+// @Tags Messages
+// @Produce json
+// @Param token path string true "Application Token"
+// @Param chatNumber path int true "Chat Number"
+// @Param messageNumber path int true "Message Number"
+// @Success 200 {object} MessageDetailResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/chats/{chatNumber}/messages/{messageNumber} [get]
+func (h *MessageHandler) Get(w http.ResponseWriter, r *http.Request) {
+	token, chatNumber, messageNumber, ok := messagePath(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := h.service.GetMessage(r.Context(), token, chatNumber, messageNumber)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, MessageDetailResponse{
+		MessageNumber: message.MessageNumber,
+		Body:          message.Body,
+		CreatedAt:     message.CreatedAt,
+		UpdatedAt:     message.UpdatedAt,
+		EditedAt:      message.EditedAt,
+	})
+}
+
+// @Summary Update message
+// @Description Queues a new body for a message. The edit is applied to the database and the search index in the background.
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param token path string true "Application Token"
+// @Param chatNumber path int true "Chat Number"
+// @Param messageNumber path int true "Message Number"
+// @Param message body createMessageRequest true "Message update request"
+// @Success 202 {object} MessageUpdateResponse
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/chats/{chatNumber}/messages/{messageNumber} [put]
+func (h *MessageHandler) Update(w http.ResponseWriter, r *http.Request) {
+	token, chatNumber, messageNumber, ok := messagePath(w, r)
+	if !ok {
+		return
+	}
+
+	var req createMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Validate the request
+	if errors := validation.ValidateStruct(req); len(errors) > 0 {
+		httputil.WriteValidationErrors(w, errors)
+		return
+	}
+
+	message, err := h.service.UpdateMessage(r.Context(), token, chatNumber, messageNumber, req.Body)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusAccepted, MessageUpdateResponse{
+		MessageNumber: message.MessageNumber,
+		Body:          message.Body,
+		EditedAt:      *message.EditedAt,
+	})
+}
+
+// @Summary Delete message
+// @Description Queues the deletion of a message from the database and the search index
+// @Tags Messages
+// @Param token path string true "Application Token"
+// @Param chatNumber path int true "Chat Number"
+// @Param messageNumber path int true "Message Number"
+// @Success 202
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /applications/{token}/chats/{chatNumber}/messages/{messageNumber} [delete]
+func (h *MessageHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	token, chatNumber, messageNumber, ok := messagePath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteMessage(r.Context(), token, chatNumber, messageNumber); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// messagePath reads the token, chat number and message number of a single
+// message route, answering 400 if a number is malformed.
+func messagePath(w http.ResponseWriter, r *http.Request) (string, int, int, bool) {
+	vars := mux.Vars(r)
+	chatNumber, err := strconv.Atoi(vars["chatNumber"])
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
+		return "", 0, 0, false
+	}
+	messageNumber, err := strconv.Atoi(vars["messageNumber"])
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid message number")
+		return "", 0, 0, false
+	}
+	return vars["token"], chatNumber, messageNumber, true
+}
+
 // @Summary Search messages
-// @Description Search messages in a chat
+// @Description Search messages in a chat. By default words match by prefix, so "hel" finds "hello", and the closest matches come first. Page with from and size, or pass next_cursor from the previous page as cursor (together with the same q and mode) to go past 10000 results.
 // @Tags Messages
 // @Accept json
 // @Produce json
+// @Param token path string true "Application Token"
 // @Param chatNumber path int true "Chat Number"
 // @Param q query string true "Search query"
-// @Success 200 {object} MessageListResponse
+// @Param mode query string false "How the query must match: prefix (start of words), substring (anywhere in words), phrase (exact phrase) or fuzzy (allowing typos)" Enums(prefix, substring, phrase, fuzzy) default(prefix)
+// @Param sort query string false "relevance (best match first), message_number or created_at" Enums(relevance, message_number, created_at) default(relevance)
+// @Param order query string false "asc or desc, only when sorting by message_number (default asc) or created_at (default desc)" Enums(asc, desc)
+// @Param from query int false "Number of results to skip" default(0)
+// @Param size query int false "Results per page" default(10)
+// @Param cursor query string false "Cursor from a previous page"
+// @Success 200 {object} MessageSearchResponse
 // @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
-// @Router /chats/{chatNumber}/messages/search [get]
+// @Router /applications/{token}/chats/{chatNumber}/messages/search [get]
 func (h *MessageHandler) Search(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	token := vars["token"]
 	chatNumber, err := strconv.Atoi(vars["chatNumber"])
 	if err != nil {
-		http.Error(w, "Invalid chat number", http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid chat number")
 		return
 	}
 
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		http.Error(w, "Search query is required", http.StatusBadRequest)
+		httputil.WriteError(w, http.StatusBadRequest, "Search query is required")
 		return
 	}
 
-	messages, err := h.service.SearchMessages(r.Context(), uint(chatNumber), query)
+	params := r.URL.Query()
+	searchQuery := service.MessageSearchQuery{
+		Query:  query,
+		Mode:   params.Get("mode"),
+		Sort:   params.Get("sort"),
+		Order:  params.Get("order"),
+		Size:   service.DefaultSearchSize,
+		Cursor: params.Get("cursor"),
+	}
+	for name, target := range map[string]*int{"from": &searchQuery.From, "size": &searchQuery.Size} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || (name == "size" && (n < 1 || n > 100)) {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid "+name)
+			return
+		}
+		*target = n
+	}
+
+	result, err := h.service.SearchMessages(r.Context(), token, chatNumber, searchQuery)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
-	response := struct {
-		Messages []struct {
-			MessageNumber int    `json:"Message Number"`
-			Body          string `json:"Body"`
-		} `json:"messages"`
-	}{
-		Messages: make([]struct {
-			MessageNumber int    `json:"Message Number"`
-			Body          string `json:"Body"`
-		}, len(messages)),
+	response := MessageSearchResponse{
+		Messages:   make([]MessageSearchHitResponse, len(result.Hits)),
+		Total:      result.Total,
+		NextCursor: result.NextCursor,
 	}
-
-	for i, msg := range messages {
-		response.Messages[i].MessageNumber = msg.MessageNumber
-		response.Messages[i].Body = msg.Body
+	for i, hit := range result.Hits {
+		response.Messages[i] = MessageSearchHitResponse{
+			MessageNumber: hit.Message.MessageNumber,
+			Body:          hit.Message.Body,
+			CreatedAt:     hit.Message.CreatedAt,
+			Score:         hit.Score,
+			Highlights:    hit.Highlights,
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	httputil.WriteJSON(w, http.StatusOK, response)
 }
 
 // Update the Create handler annotation
@@ -185,7 +368,7 @@ func (h *MessageHandler) Search(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {object} httputil.ErrorResponse
 
 // Update the GetMessages handler annotation
-// @Success 200 {array} MessageListResponse
+// @Success 200 {object} MessagePageResponse
 // @Failure 400 {object} httputil.ErrorResponse
 // @Failure 500 {object} httputil.ErrorResponse
 
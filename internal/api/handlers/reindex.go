@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"chat-system/internal/logger"
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/reindex"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// @title Reindex API
+// @version 1.0
+// @description Reindex handler rebuilds the message search index from MySQL and reports how far it has drifted
+
+type ReindexHandler struct {
+	reindexer *reindex.Reindexer
+	verifier  *reindex.Verifier
+}
+
+func NewReindexHandler(reindexer *reindex.Reindexer, verifier *reindex.Verifier) *ReindexHandler {
+	return &ReindexHandler{reindexer: reindexer, verifier: verifier}
+}
+
+type reindexRequest struct {
+	BatchSize int  `json:"batch_size"`
+	Restart   bool `json:"restart"`
+	KeepOld   bool `json:"keep_old"`
+}
+
+// @Summary Rebuild the search index
+// @Description Copies every message from MySQL into a new search index, catches up on writes made meanwhile and then switches the messages alias over. Search keeps working throughout. An interrupted run is resumed unless restart is set. Follow the returned job for progress.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Param options body reindexRequest false "Reindex options"
+// @Success 202 {object} handlers.JobResponse
+// @Header 202 {string} Location "URL of the job status"
+// @Failure 400 {object} httputil.ErrorResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 409 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/search/reindex [post]
+func (h *ReindexHandler) Reindex(w http.ResponseWriter, r *http.Request) {
+	var req reindexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.BatchSize < 0 || req.BatchSize > 10000 {
+		httputil.WriteError(w, http.StatusBadRequest, "batch_size must be between 1 and 10000")
+		return
+	}
+
+	opts := reindex.Options{BatchSize: req.BatchSize, Restart: req.Restart, KeepOld: req.KeepOld}
+	claim, err := h.reindexer.Start(r.Context(), opts)
+	if errors.Is(err, reindex.ErrRunning) {
+		httputil.WriteError(w, http.StatusConflict, "A reindex is already running")
+		return
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	// The run outlives the request; if the server stops it can be resumed
+	go func() {
+		ctx := context.Background()
+		if err := h.reindexer.Run(ctx, claim, opts); err != nil {
+			logger.Error(ctx, "Reindex failed", err)
+		}
+	}()
+
+	writeAcceptedJob(w, claim.Job, "/jobs/"+claim.Job.ID)
+}
+
+// @Summary Get the last consistency report
+// @Description Reports what the last periodic comparison of the search index with MySQL found: messages missing from the index, stale documents, and documents of deleted messages or chats, and how many of them were repaired
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin API key"
+// @Success 200 {object} handlers.ConsistencyReportResponse
+// @Failure 401 {object} httputil.ErrorResponse
+// @Failure 403 {object} httputil.ErrorResponse
+// @Failure 404 {object} httputil.ErrorResponse
+// @Failure 500 {object} httputil.ErrorResponse
+// @Router /admin/search/consistency [get]
+func (h *ReindexHandler) Consistency(w http.ResponseWriter, r *http.Request) {
+	report, err := h.verifier.LastReport(r.Context())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if report == nil {
+		httputil.WriteError(w, http.StatusNotFound, "No consistency check has finished yet")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ConsistencyReportResponse{
+		StartedAt:        report.StartedAt,
+		FinishedAt:       report.FinishedAt,
+		Repair:           report.Repair,
+		ChatsChecked:     report.ChatsChecked,
+		ChatsDrifted:     report.ChatsDrifted,
+		OrphanedChats:    report.OrphanedChats,
+		MessagesSampled:  report.MessagesSampled,
+		DocumentsSampled: report.DocumentsSampled,
+		Missing:          report.Missing,
+		Stale:            report.Stale,
+		Orphaned:         report.Orphaned,
+		Repaired:         report.Repaired,
+	})
+}
@@ -1,5 +1,10 @@
 package handlers
 
+import (
+    "encoding/json"
+    "time"
+)
+
 // Error response structures
 type ErrorResponse struct {
     Error string `json:"error"`
@@ -17,8 +22,46 @@ type MessageResponse struct {
 
 type MessageListResponse []MessageResponse
 
+// MessageDetailResponse represents a single message
+type MessageDetailResponse struct {
+    MessageNumber int        `json:"message_number"`
+    Body          string     `json:"body"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    EditedAt      *time.Time `json:"edited_at,omitempty"`
+}
+
+// MessageUpdateResponse represents an accepted message edit
+type MessageUpdateResponse struct {
+    MessageNumber int       `json:"message_number"`
+    Body          string    `json:"body"`
+    EditedAt      time.Time `json:"edited_at"`
+}
+
+// MessagePageResponse is one page of a chat's messages. NextCursor is
+// omitted on the last page.
+type MessagePageResponse struct {
+    Messages   []MessageResponse `json:"messages"`
+    NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// MessageSearchHitResponse represents a message matching a search. Score
+// is only set when sorting by relevance; Highlights are HTML-escaped
+// fragments of the body with the matched words wrapped in <em> tags
+type MessageSearchHitResponse struct {
+    MessageNumber int       `json:"Message Number"`
+    Body          string    `json:"Body"`
+    CreatedAt     time.Time `json:"created_at"`
+    Score         *float64  `json:"score,omitempty"`
+    Highlights    []string  `json:"highlights,omitempty"`
+}
+
+// MessageSearchResponse is one page of search results. Total counts every
+// matching message; NextCursor is omitted on the last page
 type MessageSearchResponse struct {
-    Messages []MessageResponse `json:"messages"`
+    Messages   []MessageSearchHitResponse `json:"messages"`
+    Total      int64                      `json:"total"`
+    NextCursor string                     `json:"next_cursor,omitempty"`
 }
 
 // Chat response structures
@@ -33,11 +76,110 @@ type ChatWithMessagesResponse struct {
 
 type ChatListResponse []ChatWithMessagesResponse
 
+// ChatDetailResponse represents a single chat
+type ChatDetailResponse struct {
+    ChatNumber    int       `json:"chat_number"`
+    MessagesCount int       `json:"messages_count"`
+    CreatedAt     time.Time `json:"created_at"`
+    UpdatedAt     time.Time `json:"updated_at"`
+}
+
 // ApplicationChatResponse represents the chat information for an application
 type ApplicationChatResponse struct {
     ChatNumber int `json:"Chat Number"`
     Messages   int `json:"Messages"`
 }
 
+// ApplicationDetailResponse represents a single application
+type ApplicationDetailResponse struct {
+    Token      string    `json:"token"`
+    Name       string    `json:"name"`
+    Plan       string    `json:"plan"`
+    ChatsCount int       `json:"chats_count"`
+    CreatedAt  time.Time `json:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TokenResponse represents an application's newly issued token
+type TokenResponse struct {
+    Token                  string     `json:"token"`
+    PreviousTokenExpiresAt *time.Time `json:"previous_token_expires_at,omitempty"`
+}
+
+// ApplicationSummaryResponse represents an application in a list. Tokens
+// are never listed; TokenPrefix only helps tell applications apart, and the
+// management routes take the ID in place of the token
+type ApplicationSummaryResponse struct {
+    ID          uint      `json:"id"`
+    Name        string    `json:"name"`
+    TokenPrefix string    `json:"token_prefix"`
+    ChatsCount  int       `json:"chats_count"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
 // ApplicationListResponse represents a list of applications
-type ApplicationListResponse []ApplicationResponse
+type ApplicationListResponse []ApplicationSummaryResponse
+
+// DeadJobResponse represents a queue job that exhausted its retries
+type DeadJobResponse struct {
+    ID         string          `json:"id"`
+    Type       string          `json:"type"`
+    Payload    json.RawMessage `json:"payload" swaggertype:"object"`
+    Attempts   int             `json:"attempts"`
+    LastError  string          `json:"last_error"`
+    EnqueuedAt time.Time       `json:"enqueued_at"`
+    FailedAt   *time.Time      `json:"failed_at,omitempty"`
+}
+
+// DeadJobListResponse represents a page of dead-lettered jobs
+type DeadJobListResponse struct {
+    Jobs  []DeadJobResponse `json:"jobs"`
+    Total int64             `json:"total"`
+}
+
+// DeadJobActionResponse reports how many dead-lettered jobs were affected
+type DeadJobActionResponse struct {
+    Count int64 `json:"count"`
+}
+
+// APIKeyResponse represents an admin API key. Key is only set when the key
+// is created
+type APIKeyResponse struct {
+    ID         uint       `json:"id"`
+    Name       string     `json:"name"`
+    Prefix     string     `json:"prefix"`
+    Scopes     []string   `json:"scopes"`
+    Key        string     `json:"key,omitempty"`
+    LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+    RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// JobResponse reports the progress of a background job
+type JobResponse struct {
+    ID        string           `json:"id"`
+    Type      string           `json:"type"`
+    Target    string           `json:"target"`
+    Status    string           `json:"status"`
+    Error     string           `json:"error,omitempty"`
+    Progress  map[string]int64 `json:"progress"`
+    CreatedAt time.Time        `json:"created_at"`
+    UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// ConsistencyReportResponse describes what the last search index
+// consistency check found
+type ConsistencyReportResponse struct {
+    StartedAt        time.Time `json:"started_at"`
+    FinishedAt       time.Time `json:"finished_at"`
+    Repair           bool      `json:"repair"`
+    ChatsChecked     int64     `json:"chats_checked"`
+    ChatsDrifted     int64     `json:"chats_drifted"`
+    OrphanedChats    int64     `json:"orphaned_chats"`
+    MessagesSampled  int64     `json:"messages_sampled"`
+    DocumentsSampled int64     `json:"documents_sampled"`
+    Missing          int64     `json:"missing"`
+    Stale            int64     `json:"stale"`
+    Orphaned         int64     `json:"orphaned"`
+    Repaired         int64     `json:"repaired"`
+}
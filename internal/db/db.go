@@ -22,7 +22,27 @@ var (
 )
 
 func Connect() {
-	// Connect to MySQL
+	ConnectMySQL()
+
+	// Connect to Redis
+	Redis = redis.NewClient(&redis.Options{
+		Addr:     os.Getenv("REDIS_HOST") + ":6379",
+		Password: os.Getenv("REDIS_PASSWORD"),
+		DB:       0,
+	})
+
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		migrations.RunMigrations(GormDB)
+	}
+
+	// Connect to Elasticsearch
+	setupElasticsearch()
+	log.Println("Connected to database and services")
+}
+
+// ConnectMySQL opens only the MySQL connection, for tools such as the
+// migrate command that need nothing else.
+func ConnectMySQL() {
 	dbURL := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
 		os.Getenv("DB_USER"),     // Username
 		os.Getenv("DB_PASSWORD"), // Password
@@ -56,17 +76,4 @@ func Connect() {
 
 	DB = sqlDB
 	GormDB = db
-
-	// Connect to Redis
-	Redis = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_HOST") + ":6379",
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       0,
-	})
-
-	migrations.RunMigrations(db)
-
-	// Connect to Elasticsearch
-	setupElasticsearch()
-	log.Println("Connected to database and services")
 }
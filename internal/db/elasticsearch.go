@@ -1,10 +1,11 @@
 package db
 
 import (
-	"fmt"
+	"chat-system/internal/search"
+	"context"
 	"log"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 )
@@ -25,39 +26,13 @@ func setupElasticsearch() {
 
 	ES = client
 
-	// Create message index with mapping
 	log.Println("Elasticsearch client created Successfully")
-	createMessageIndex()
-}
-
-func createMessageIndex() error {
-	mapping := `{
-		"mappings": {
-			"properties": {
-				"chat_id": { "type": "keyword" },
-				"message_number": { "type": "integer" },
-				"body": {
-					"type": "text",
-					"analyzer": "standard"
-				},
-				"created_at": { "type": "date" }
-			}
-		}
-	}`
-
-	res, err := ES.Indices.Create(
-		"messages",
-		ES.Indices.Create.WithBody(strings.NewReader(mapping)),
-	)
-
-	if err != nil {
-		return fmt.Errorf("cannot create index: %w", err)
-	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return fmt.Errorf("error creating index: %s", res.String())
+	// The service cannot search or index with a wrong mapping, so refuse to
+	// start rather than let Elasticsearch map documents on its own
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := search.EnsureIndex(ctx, ES); err != nil {
+		log.Fatalf("Error preparing the message index: %v", err)
 	}
-
-	return nil
 }
@@ -2,21 +2,149 @@ package migrations
 
 import (
 	"chat-system/internal/db/models"
+	"context"
+	"fmt"
 	"log"
 
 	"gorm.io/gorm"
 )
 
+// baselineVersion is the migration that matches the schema AutoMigrate used
+// to create before versioned migrations were introduced.
+const baselineVersion = 1
+
+// RunMigrations brings the schema up to date. Databases created by the old
+// AutoMigrate startup are adopted first by recording the baseline migration
+// as applied; after that only the versioned SQL files change the schema.
 func RunMigrations(db *gorm.DB) {
-	err := db.AutoMigrate(
-		&models.Application{},
-		&models.Chat{},
-		&models.Message{},
-	)
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
+	migrator, err := NewMigrator(sqlDB, Files)
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+
+	ctx := context.Background()
+	if err := migrator.Baseline(ctx, baselineVersion, func() (bool, error) {
+		return adoptLegacySchema(db)
+	}); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
 
+	applied, err := migrator.Up(ctx)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+	}
 	log.Println("Database migration completed")
 }
+
+// adoptLegacySchema reports whether the database was created by AutoMigrate
+// and, if so, finishes the last AutoMigrate-era changes so the schema
+// matches the baseline migration.
+func adoptLegacySchema(db *gorm.DB) (bool, error) {
+	if !db.Migrator().HasTable("applications") {
+		return false, nil
+	}
+
+	if err := dropCompositeIndexColumns(db); err != nil {
+		return false, err
+	}
+
+	// Only the indexes the baseline adds; later columns come from their own
+	// migrations, so the models must not be auto-migrated here
+	m := db.Migrator()
+	for _, index := range []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.Chat{}, "idx_application_chat_number"},
+		{&models.Message{}, "idx_chat_message_number"},
+	} {
+		if m.HasIndex(index.model, index.name) {
+			continue
+		}
+		if err := m.CreateIndex(index.model, index.name); err != nil {
+			return false, err
+		}
+	}
+
+	log.Println("Adopted existing schema as the migration baseline")
+	return true, nil
+}
+
+// compositeIndexTable describes a table that carried the unused
+// composite_index column, and the unique index that replaces it.
+type compositeIndexTable struct {
+	model       interface{}
+	table       string
+	staleIndex  []string
+	uniqueIndex string
+	columns     string
+}
+
+// dropCompositeIndexColumns removes the never-populated composite_index
+// columns and their indexes so the real composite unique indexes on
+// (application_id, chat_number) and (chat_id, message_number) can be
+// created. Existing duplicates would make that fail, so they are reported
+// up front instead of being deleted.
+func dropCompositeIndexColumns(db *gorm.DB) error {
+	tables := []compositeIndexTable{
+		{
+			model:       &models.Chat{},
+			table:       "chats",
+			staleIndex:  []string{"idx_application_chat_number_application_id", "idx_application_chat_number"},
+			uniqueIndex: "idx_application_chat_number",
+			columns:     "application_id, chat_number",
+		},
+		{
+			model:       &models.Message{},
+			table:       "messages",
+			staleIndex:  []string{"idx_chat_message_number"},
+			uniqueIndex: "idx_chat_message_number",
+			columns:     "chat_id, message_number",
+		},
+	}
+
+	m := db.Migrator()
+	for _, t := range tables {
+		if !m.HasTable(t.table) || !m.HasColumn(t.model, "composite_index") {
+			continue
+		}
+
+		var duplicates int64
+		err := db.Raw(fmt.Sprintf(
+			"SELECT COUNT(*) FROM (SELECT 1 FROM %s GROUP BY %s HAVING COUNT(*) > 1) d",
+			t.table, t.columns,
+		)).Scan(&duplicates).Error
+		if err != nil {
+			return err
+		}
+		if duplicates > 0 {
+			return fmt.Errorf("%s has %d duplicated (%s) pairs, resolve them before %s can be made unique",
+				t.table, duplicates, t.columns, t.uniqueIndex)
+		}
+
+		for _, index := range t.staleIndex {
+			if m.HasIndex(t.model, index) {
+				if err := m.DropIndex(t.model, index); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := m.DropColumn(t.model, "composite_index"); err != nil {
+			return err
+		}
+
+		log.Printf("Dropped %s.composite_index in favour of unique (%s)", t.table, t.columns)
+	}
+
+	return nil
+}
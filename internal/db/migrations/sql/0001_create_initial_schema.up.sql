@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS applications (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    chats_count BIGINT DEFAULT 0,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    deleted_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uni_applications_token (token),
+    KEY idx_applications_deleted_at (deleted_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS chats (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    application_id BIGINT UNSIGNED NOT NULL,
+    chat_number BIGINT NOT NULL,
+    messages_count BIGINT DEFAULT 0,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY idx_application_chat_number (application_id, chat_number),
+    CONSTRAINT fk_chats_application FOREIGN KEY (application_id) REFERENCES applications (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS messages (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    chat_id BIGINT UNSIGNED NOT NULL,
+    message_number BIGINT NOT NULL,
+    body TEXT NOT NULL,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY idx_chat_message_number (chat_id, message_number),
+    CONSTRAINT fk_messages_chat FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
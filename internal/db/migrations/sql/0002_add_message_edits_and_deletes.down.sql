@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    DROP KEY idx_messages_deleted_at,
+    DROP COLUMN deleted_at,
+    DROP COLUMN edited_at;
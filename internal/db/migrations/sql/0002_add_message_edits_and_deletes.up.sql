@@ -0,0 +1,6 @@
+-- Messages can be edited and deleted. Deleted messages are kept as soft-deleted
+-- rows so their numbers are never reused and late retries cannot recreate them.
+ALTER TABLE messages
+    ADD COLUMN edited_at DATETIME(3) NULL,
+    ADD COLUMN deleted_at DATETIME(3) NULL,
+    ADD KEY idx_messages_deleted_at (deleted_at);
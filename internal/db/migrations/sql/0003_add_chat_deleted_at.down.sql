@@ -0,0 +1,3 @@
+ALTER TABLE chats
+    DROP KEY idx_chats_deleted_at,
+    DROP COLUMN deleted_at;
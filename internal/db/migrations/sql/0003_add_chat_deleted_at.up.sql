@@ -0,0 +1,4 @@
+-- Deleted chats are hidden right away and removed by a background job.
+ALTER TABLE chats
+    ADD COLUMN deleted_at DATETIME(3) NULL,
+    ADD KEY idx_chats_deleted_at (deleted_at);
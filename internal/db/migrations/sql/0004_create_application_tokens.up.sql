@@ -0,0 +1,14 @@
+-- Tokens an application used before its last rotation. They keep working
+-- until expires_at unless revoked; applications.token holds the current one.
+CREATE TABLE IF NOT EXISTS application_tokens (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    application_id BIGINT UNSIGNED NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    revoked_at DATETIME(3) NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uni_application_tokens_token (token),
+    KEY idx_application_tokens_application_id (application_id),
+    CONSTRAINT fk_application_tokens_application FOREIGN KEY (application_id) REFERENCES applications (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,14 @@
+-- Admin API keys for the management endpoints. Only a SHA-256 hash of each
+-- key is stored; prefix is kept so operators can tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    last_used_at DATETIME(3) NULL,
+    revoked_at DATETIME(3) NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uni_api_keys_key_hash (key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
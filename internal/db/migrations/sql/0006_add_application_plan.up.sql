@@ -0,0 +1,3 @@
+-- The plan an application is on selects its rate limits and daily quota.
+ALTER TABLE applications
+    ADD COLUMN plan VARCHAR(32) NOT NULL DEFAULT 'free';
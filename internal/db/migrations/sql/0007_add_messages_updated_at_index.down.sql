@@ -0,0 +1 @@
+ALTER TABLE messages DROP KEY idx_messages_updated_at;
@@ -0,0 +1,4 @@
+-- Lets the reindex catch up on messages written while it runs without
+-- scanning the whole table.
+ALTER TABLE messages
+    ADD KEY idx_messages_updated_at (updated_at);
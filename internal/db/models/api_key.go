@@ -0,0 +1,26 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey grants access to the management endpoints. Only the SHA-256 hash
+// of the key is stored; Scopes is a comma-separated list.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	Name       string `gorm:"size:255;not null"`
+	Prefix     string `gorm:"size:16;not null"`
+	KeyHash    string `gorm:"size:64;not null;unique"`
+	Scopes     string `gorm:"size:255;not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
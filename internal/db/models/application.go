@@ -11,6 +11,7 @@ type Application struct {
 	Name       string `gorm:"size:255;not null"`
 	Token      string `gorm:"size:255;not null;unique;index"`
 	ChatsCount int    `gorm:"default:0"`
+	Plan       string `gorm:"size:32;not null;default:free"`
 	Chats      []Chat `gorm:"foreignKey:ApplicationID"`
 
 	CreatedAt time.Time
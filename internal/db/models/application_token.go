@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// ApplicationToken is a token an application used before a rotation. It
+// stays valid until ExpiresAt unless it is revoked first.
+type ApplicationToken struct {
+	ID            uint   `gorm:"primaryKey"`
+	ApplicationID uint   `gorm:"not null;index"`
+	Token         string `gorm:"size:255;not null;unique"`
+	ExpiresAt     time.Time
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
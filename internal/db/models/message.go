@@ -2,16 +2,19 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Message struct {
 	ID            uint   `gorm:"primaryKey"`
-	ChatID        uint   `gorm:"not null;index"`
-	MessageNumber int    `gorm:"not null;index:idx_chat_message_number"`
+	ChatID        uint   `gorm:"not null;uniqueIndex:idx_chat_message_number"`
+	MessageNumber int    `gorm:"not null;uniqueIndex:idx_chat_message_number"`
 	Body          string `gorm:"type:text;not null"`
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	EditedAt      *time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
 
-	CompositeIndex string `gorm:"index:idx_chat_message_number,unique;not null"`
-	Chat           Chat   `gorm:"constraint:OnDelete:CASCADE"`
+	Chat Chat `gorm:"constraint:OnDelete:CASCADE"`
 }
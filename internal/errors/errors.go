@@ -28,6 +28,13 @@ var (
 		}
 	}
 
+	ErrBadRequest = func(message string) *AppError {
+		return &AppError{
+			Code:    http.StatusBadRequest,
+			Message: message,
+		}
+	}
+
 	ErrNotFound = func(resource string) *AppError {
 		return &AppError{
 			Code:    http.StatusNotFound,
@@ -35,11 +42,23 @@ var (
 		}
 	}
 
+	ErrConflict = func(message string) *AppError {
+		return &AppError{
+			Code:    http.StatusConflict,
+			Message: message,
+		}
+	}
+
 	ErrUnauthorized = &AppError{
 		Code:    http.StatusUnauthorized,
 		Message: "Unauthorized access",
 	}
 
+	ErrForbidden = &AppError{
+		Code:    http.StatusForbidden,
+		Message: "Insufficient permissions",
+	}
+
 	ErrInternalServer = func(err error) *AppError {
 		return &AppError{
 			Code:    http.StatusInternalServerError,
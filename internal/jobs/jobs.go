@@ -0,0 +1,155 @@
+// Package jobs tracks the progress of long-running background jobs, such as
+// deleting an application, in Redis so clients can poll their status.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+
+	keyPrefix = "job:"
+
+	// Finished jobs stay visible for a week; unfinished ones never expire
+	finishedTTL = 7 * 24 * time.Hour
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is the status of a background job. Target names what the job works
+// on and is shown to whoever polls the job, so it must not hold secrets.
+// ApplicationID is the application the job belongs to, if any. Counters
+// hold job-specific progress, e.g. how many messages were deleted so far.
+type Job struct {
+	ID            string
+	Type          string
+	Target        string
+	ApplicationID uint
+	Status        string
+	Error         string
+	Counters      map[string]int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Store keeps job statuses in Redis hashes.
+type Store struct {
+	redis *redis.Client
+}
+
+func NewStore(redis *redis.Client) *Store {
+	return &Store{redis: redis}
+}
+
+func key(id string) string {
+	return keyPrefix + id
+}
+
+// Create records a new queued job and returns it. appID is zero for jobs
+// that do not belong to an application.
+func (s *Store) Create(ctx context.Context, jobType, target string, appID uint) (*Job, error) {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:            uuid.New().String(),
+		Type:          jobType,
+		Target:        target,
+		ApplicationID: appID,
+		Status:        StatusQueued,
+		Counters:      map[string]int64{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err := s.redis.HSet(ctx, key(job.ID),
+		"type", job.Type,
+		"target", job.Target,
+		"application_id", job.ApplicationID,
+		"status", job.Status,
+		"created_at", now.Format(time.RFC3339Nano),
+		"updated_at", now.Format(time.RFC3339Nano),
+	).Err()
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns a job's current status.
+func (s *Store) Get(ctx context.Context, id string) (*Job, error) {
+	fields, err := s.redis.HGetAll(ctx, key(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	job := &Job{ID: id, Counters: map[string]int64{}}
+	for field, value := range fields {
+		switch field {
+		case "type":
+			job.Type = value
+		case "target":
+			job.Target = value
+		case "application_id":
+			if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+				job.ApplicationID = uint(id)
+			}
+		case "status":
+			job.Status = value
+		case "error":
+			job.Error = value
+		case "created_at":
+			job.CreatedAt, _ = time.Parse(time.RFC3339Nano, value)
+		case "updated_at":
+			job.UpdatedAt, _ = time.Parse(time.RFC3339Nano, value)
+		default:
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				job.Counters[field] = n
+			}
+		}
+	}
+	return job, nil
+}
+
+// SetStatus moves a job to a new status. Completed and failed jobs expire
+// after a week.
+func (s *Store) SetStatus(ctx context.Context, id, status string, jobErr error) error {
+	fields := []interface{}{"status", status, "updated_at", time.Now().UTC().Format(time.RFC3339Nano)}
+	if jobErr != nil {
+		fields = append(fields, "error", jobErr.Error())
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.HSet(ctx, key(id), fields...)
+	if status == StatusCompleted || status == StatusFailed {
+		pipe.Expire(ctx, key(id), finishedTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetCounter sets a progress counter, e.g. the total amount of work.
+func (s *Store) SetCounter(ctx context.Context, id, counter string, value int64) error {
+	return s.redis.HSet(ctx, key(id), counter, value, "updated_at", time.Now().UTC().Format(time.RFC3339Nano)).Err()
+}
+
+// IncrCounter adds n to a progress counter and returns its new value.
+func (s *Store) IncrCounter(ctx context.Context, id, counter string, n int64) (int64, error) {
+	value, err := s.redis.HIncrBy(ctx, key(id), counter, n).Result()
+	if err != nil {
+		return 0, fmt.Errorf("updating %s of job %s: %w", counter, id, err)
+	}
+	return value, nil
+}
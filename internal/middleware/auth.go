@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"chat-system/internal/errors"
+	"chat-system/internal/logger"
+	"chat-system/internal/service"
+	stderrors "errors"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireScope only lets requests through that carry an admin API key
+// granting one of scopes in the X-Admin-Key header. Missing, unknown and
+// revoked keys get a 401, keys without any of the scopes a 403.
+func RequireScope(keys *service.APIKeyService, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, err := keys.Authenticate(r.Context(), r.Header.Get("X-Admin-Key"))
+			if err != nil {
+				logger.Error(r.Context(), "API key check failed", err)
+				respondWithError(w, errors.ErrInternalServer(err))
+				return
+			}
+			if granted == nil {
+				respondWithError(w, errors.ErrUnauthorized)
+				return
+			}
+			if !slices.ContainsFunc(scopes, func(scope string) bool { return slices.Contains(granted, scope) }) {
+				respondWithError(w, errors.ErrForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAppToken only lets requests through whose {token} route variable
+// is a valid application token, current or still in its grace period. It
+// must be installed on a router whose routes declare {token}.
+func RequireAppToken(tokens *service.TokenResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := tokens.Resolve(r.Context(), mux.Vars(r)["token"])
+			if stderrors.Is(err, service.ErrApplicationNotFound) {
+				respondWithError(w, errors.ErrUnauthorized)
+				return
+			}
+			if err != nil {
+				logger.Error(r.Context(), "Application token check failed", err)
+				respondWithError(w, errors.ErrInternalServer(err))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
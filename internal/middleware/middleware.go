@@ -3,6 +3,7 @@ package middleware
 import (
 	"chat-system/internal/errors"
 	"chat-system/internal/logger"
+	"chat-system/internal/pkg/clientip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -43,6 +44,18 @@ func ErrorHandler(next http.Handler) http.Handler {
 	})
 }
 
+// ClientIP works out the real client address of each request, looking
+// through trusted proxies, and stores it for RequestLogger, the rate
+// limiter and anything else that needs it (see clientip.FromRequest).
+func ClientIP(resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := clientip.WithClientIP(r.Context(), resolver.ClientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -74,6 +87,7 @@ func RequestLogger(next http.Handler) http.Handler {
 				"size":        rw.size,
 				"duration_ms": time.Since(start).Milliseconds(),
 				"user_agent":  r.UserAgent(),
+				"client_ip":   clientip.FromRequest(r),
 				"remote_addr": r.RemoteAddr,
 			})
 	})
@@ -1,49 +1,154 @@
 package middleware
 
 import (
+	"chat-system/internal/logger"
+	"chat-system/internal/pkg/clientip"
+	"chat-system/internal/ratelimit"
+	"chat-system/internal/service"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/gorilla/mux"
 )
 
+// RateLimiter limits requests per client IP and, for routes that carry an
+// application token, per application according to its plan. Routes are
+// told apart by their mux route name. Creating a message also takes one
+// from the application's daily quota.
+//
+// Limits are shared by all replicas through Redis. If Redis cannot be
+// reached requests are let through rather than failing the API.
 type RateLimiter struct {
-    ips    map[string]*rate.Limiter
-    mu     sync.RWMutex
-    rate   rate.Limit
-    burst  int
-}
-
-func NewRateLimiter(r rate.Limit, b int) *RateLimiter {
-    return &RateLimiter{
-        ips:   make(map[string]*rate.Limiter),
-        rate:  r,
-        burst: b,
-    }
-}
-func (rl *RateLimiter) getLimiterForIP(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	limiter, exists := rl.ips[ip]
-	if !exists {
-		limiter = rate.NewLimiter(rl.rate, rl.burst)
-		rl.ips[ip] = limiter
-	}
+	limiter *ratelimit.Limiter
+	config  ratelimit.Config
+	tokens  *service.TokenResolver
+	apps    *service.ApplicationService
+}
 
-	return limiter
+func NewRateLimiter(limiter *ratelimit.Limiter, config ratelimit.Config, tokens *service.TokenResolver, apps *service.ApplicationService) *RateLimiter {
+	return &RateLimiter{limiter: limiter, config: config, tokens: tokens, apps: apps}
 }
 
+// RateLimit must be installed with Router.Use so the matched route is known.
 func (rl *RateLimiter) RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        ip := r.RemoteAddr
-        limiter := rl.getLimiterForIP(ip)
-        if !limiter.Allow() {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-            return
-        }
-        next.ServeHTTP(w, r)
-    })
+		ctx := r.Context()
+		route := routeName(r)
+
+		if limit, ok := rl.config.IP.For(route); ok {
+			result, err := rl.limiter.Allow(ctx, fmt.Sprintf("ip:%s:%s", route, clientip.FromRequest(r)), limit)
+			if rl.reject(ctx, w, result, err) {
+				return
+			}
+		}
+
+		token, ok := mux.Vars(r)["token"]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Unknown tokens are turned away by the token check further on
+		appID, plan, err := rl.plan(ctx, token)
+		if errors.Is(err, service.ErrApplicationNotFound) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			logger.Error(ctx, "Rate limit plan lookup failed", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if limit, ok := plan.Routes.For(route); ok {
+			result, err := rl.limiter.Allow(ctx, fmt.Sprintf("app:%s:%d", route, appID), limit)
+			if rl.reject(ctx, w, result, err) {
+				return
+			}
+		}
+
+		if route != ratelimit.RouteMessagesCreate || plan.DailyMessages == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		consumed, err := rl.limiter.ConsumeMessage(ctx, appID, plan.DailyMessages)
+		if err != nil {
+			logger.Error(ctx, "Message quota check failed", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		setLimitHeaders(w, "X-Quota", consumed)
+		if !consumed.Allowed {
+			w.Header().Set("Retry-After", retryAfter(consumed.Reset))
+			http.Error(w, "Daily message quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		rw := &ResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		// Only messages that were accepted count against the quota
+		if rw.status >= http.StatusBadRequest {
+			if err := rl.limiter.RefundMessage(context.WithoutCancel(ctx), consumed); err != nil {
+				logger.Error(ctx, "Message quota refund failed", err)
+			}
+		}
+	})
 }
 
-	
\ No newline at end of file
+func (rl *RateLimiter) plan(ctx context.Context, token string) (uint, ratelimit.Plan, error) {
+	appID, err := rl.tokens.Resolve(ctx, token)
+	if err != nil {
+		return 0, ratelimit.Plan{}, err
+	}
+
+	name, err := rl.apps.Plan(ctx, appID)
+	if err != nil {
+		return 0, ratelimit.Plan{}, err
+	}
+	return appID, rl.config.Plan(name), nil
+}
+
+// reject writes the limit headers and, once the limit is exceeded, a 429.
+// It reports whether the request was turned away.
+func (rl *RateLimiter) reject(ctx context.Context, w http.ResponseWriter, result ratelimit.Result, err error) bool {
+	if err != nil {
+		logger.Error(ctx, "Rate limit check failed", err)
+		return false
+	}
+
+	setLimitHeaders(w, "X-RateLimit", result)
+	if result.Allowed {
+		return false
+	}
+
+	w.Header().Set("Retry-After", retryAfter(result.Reset))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// setLimitHeaders reports a limit as <prefix>-Limit, -Remaining and -Reset,
+// the latter in seconds. A later, more specific limit replaces an earlier
+// one's headers.
+func setLimitHeaders(w http.ResponseWriter, prefix string, result ratelimit.Result) {
+	w.Header().Set(prefix+"-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set(prefix+"-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set(prefix+"-Reset", retryAfter(result.Reset))
+}
+
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return ratelimit.DefaultRoute
+}
@@ -0,0 +1,162 @@
+// Package clientip works out which client a request came from when the
+// server sits behind load balancers or reverse proxies.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Resolver derives the client IP of a request. Forwarding headers are only
+// believed when the hop that set them is a trusted proxy, so clients that
+// connect directly cannot spoof their address.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver trusts the given proxies, each an IP address or CIDR range.
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			r.trusted = append(r.trusted, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return r, nil
+}
+
+// ResolverFromEnv trusts the comma-separated proxies in TRUSTED_PROXIES.
+// With none configured, the connecting address is always the client.
+func ResolverFromEnv() (*Resolver, error) {
+	return NewResolver(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","))
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made a request. Starting
+// from the connecting address it walks the forwarding chain backwards past
+// trusted proxies and stops at the first hop it does not trust. Forwarded
+// takes precedence over X-Forwarded-For, which takes precedence over
+// X-Real-IP.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remote, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(remote) {
+		return remote.String()
+	}
+
+	if chain := forwardedFor(req.Header.Values("Forwarded")); len(chain) > 0 {
+		return r.walk(remote, chain).String()
+	}
+	if chain := splitList(req.Header.Values("X-Forwarded-For")); len(chain) > 0 {
+		return r.walk(remote, chain).String()
+	}
+	if addr, ok := parseAddr(req.Header.Get("X-Real-IP")); ok {
+		return addr.String()
+	}
+	return remote.String()
+}
+
+// walk goes through chain from the nearest hop outwards. An entry that is
+// not an address, such as "unknown", ends the walk at the last trusted hop.
+func (r *Resolver) walk(remote netip.Addr, chain []string) netip.Addr {
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers in
+// order, leaving obfuscated and unknown values in place.
+func forwardedFor(headers []string) []string {
+	var chain []string
+	for _, element := range splitList(headers) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				chain = append(chain, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return chain
+}
+
+func splitList(headers []string) []string {
+	var items []string
+	for _, header := range headers {
+		for _, item := range strings.Split(header, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseAddr accepts an IP address with or without a port, IPv6 addresses
+// optionally in brackets.
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// WithClientIP stores a request's client IP in its context.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromRequest returns the client IP stored by WithClientIP, or the
+// connecting address if none was stored.
+func FromRequest(req *http.Request) string {
+	if ip, ok := req.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	if addr, ok := parseAddr(req.RemoteAddr); ok {
+		return addr.String()
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,165 @@
+package httputil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLimit = 10
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Pagination holds the validated paging parameters of a list request. In
+// page mode Page and Limit select an offset; in cursor mode Cursor (empty
+// for the first page) continues from where the previous page ended.
+type Pagination struct {
+	Page       int
+	Limit      int
+	CursorMode bool
+	Cursor     string
+}
+
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// Page is the envelope every paginated list is returned in. Next is the URL
+// of the following page and is omitted on the last one.
+type Page struct {
+	Data  interface{} `json:"data"`
+	Page  int         `json:"page,omitempty"`
+	Limit int         `json:"limit"`
+	Total int64       `json:"total"`
+	Next  string      `json:"next,omitempty"`
+}
+
+// ParsePagination reads page, limit and cursor from the query string.
+// Cursor mode is selected with mode=cursor or by passing a cursor. A page
+// below 1, a non-numeric value or a limit above MaxLimit is rejected.
+func ParsePagination(r *http.Request) (Pagination, error) {
+	query := r.URL.Query()
+	p := Pagination{Page: 1, Limit: DefaultLimit}
+
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return p, errors.New("page must be a positive integer")
+		}
+		p.Page = page
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.Limit = limit
+	}
+
+	p.Cursor = query.Get("cursor")
+	p.CursorMode = p.Cursor != "" || query.Get("mode") == "cursor"
+	if p.CursorMode && query.Get("page") != "" {
+		return p, errors.New("page cannot be combined with cursor mode")
+	}
+
+	return p, nil
+}
+
+// EncodeCursor turns the position a list stopped at into an opaque token.
+func EncodeCursor(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor reads a token produced by EncodeCursor into v.
+func DecodeCursor(cursor string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// WritePage writes a page of results in page mode, linking to the first,
+// previous, next and last pages.
+func WritePage(w http.ResponseWriter, r *http.Request, p Pagination, data interface{}, total int64) {
+	lastPage := int((total + int64(p.Limit) - 1) / int64(p.Limit))
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	links := map[string]string{
+		"first": pageURL(r, "page", "1"),
+		"last":  pageURL(r, "page", strconv.Itoa(lastPage)),
+	}
+	if p.Page > 1 {
+		links["prev"] = pageURL(r, "page", strconv.Itoa(min(p.Page-1, lastPage)))
+	}
+
+	page := Page{Data: data, Page: p.Page, Limit: p.Limit, Total: total}
+	if p.Page < lastPage {
+		page.Next = pageURL(r, "page", strconv.Itoa(p.Page+1))
+		links["next"] = page.Next
+	}
+
+	writeLinks(w, links)
+	WriteJSON(w, http.StatusOK, page)
+}
+
+// WriteCursorPage writes a page of results in cursor mode. nextCursor is
+// empty when there is nothing after this page.
+func WriteCursorPage(w http.ResponseWriter, r *http.Request, p Pagination, data interface{}, total int64, nextCursor string) {
+	links := map[string]string{
+		"first": pageURL(r, "cursor", ""),
+	}
+
+	page := Page{Data: data, Limit: p.Limit, Total: total}
+	if nextCursor != "" {
+		page.Next = pageURL(r, "cursor", nextCursor)
+		links["next"] = page.Next
+	}
+
+	writeLinks(w, links)
+	WriteJSON(w, http.StatusOK, page)
+}
+
+// pageURL returns the request's path and query with one parameter
+// replaced, or removed when value is empty. Cursor links keep mode=cursor
+// so the first page stays in cursor mode.
+func pageURL(r *http.Request, param, value string) string {
+	query := r.URL.Query()
+	query.Del(param)
+	if value != "" {
+		query.Set(param, value)
+	}
+	if param == "cursor" {
+		query.Del("page")
+		query.Set("mode", "cursor")
+	}
+
+	if encoded := query.Encode(); encoded != "" {
+		return r.URL.Path + "?" + encoded
+	}
+	return r.URL.Path
+}
+
+func writeLinks(w http.ResponseWriter, links map[string]string) {
+	var parts []string
+	for _, rel := range []string{"first", "prev", "next", "last"} {
+		if link, ok := links[rel]; ok {
+			parts = append(parts, fmt.Sprintf(`<%s>; rel="%s"`, link, rel))
+		}
+	}
+	w.Header().Set("Link", strings.Join(parts, ", "))
+}
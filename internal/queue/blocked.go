@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// blockedKey maps the key of every job waiting for a retry to that
+	// job's ID. Later jobs with the same key are held until it is done.
+	blockedKey    = "message_queue:blocked"
+	heldKeyPrefix = "message_queue:held:"
+)
+
+// dequeueScript takes jobs from the consuming end of a shard until it finds
+// one whose key is not blocked by another job's retry. Jobs with a blocked
+// key are parked on their key's held list instead, newest on the left.
+// Jobs without a key predate blocking and are never held.
+var dequeueScript = redis.NewScript(`
+while true do
+	local raw = redis.call('RPOPLPUSH', KEYS[1], KEYS[2])
+	if not raw then
+		return false
+	end
+	local ok, job = pcall(cjson.decode, raw)
+	if not ok or type(job) ~= 'table' or type(job.key) ~= 'number' then
+		return raw
+	end
+	local key = string.format('%d', job.key)
+	local owner = redis.call('HGET', KEYS[3], key)
+	if not owner or owner == job.id then
+		return raw
+	end
+	redis.call('LREM', KEYS[2], 1, raw)
+	redis.call('LPUSH', ARGV[1] .. key, raw)
+end
+`)
+
+// holdScript parks one in-flight job on its key's held list if another
+// job's retry blocks the key.
+var holdScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], ARGV[1])
+if not owner or owner == ARGV[2] then
+	return 0
+end
+redis.call('LREM', KEYS[2], 1, ARGV[3])
+redis.call('LPUSH', KEYS[3], ARGV[3])
+return 1
+`)
+
+// unblockScript lifts a key's block if the given job holds it and moves the
+// held jobs to the consuming end of their shard, newest first so the oldest
+// is picked up first.
+var unblockScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+local n = 0
+while redis.call('LMOVE', KEYS[2], KEYS[3], 'LEFT', 'RIGHT') do
+	n = n + 1
+end
+return n
+`)
+
+func heldKey(key uint) string {
+	return fmt.Sprintf("%s%d", heldKeyPrefix, key)
+}
+
+// Hold parks an in-flight job until the retry of an earlier job with the
+// same key is done, and reports whether it did. Dequeue already holds such
+// jobs; consumers call Hold for jobs they took in the same batch as a job
+// that just failed.
+func (mq *MessageQueue) Hold(ctx context.Context, consumerID string, qm *QueuedMessage) (bool, error) {
+	if qm.Key == 0 {
+		return false, nil
+	}
+
+	keys := []string{blockedKey, processingKeyPrefix + consumerID, heldKey(qm.Key)}
+	held, err := holdScript.Run(ctx, mq.redis, keys, qm.Key, qm.ID, qm.raw).Int()
+	if err != nil {
+		return false, err
+	}
+	return held > 0, nil
+}
+
+// unblock releases the jobs held behind qm once it no longer needs to run
+// before them, because it succeeded or was dead-lettered.
+func (mq *MessageQueue) unblock(ctx context.Context, qm *QueuedMessage) error {
+	if qm.Key == 0 || qm.Attempts == 0 {
+		return nil
+	}
+
+	keys := []string{blockedKey, heldKey(qm.Key), mq.pendingKey(qm.Shard)}
+	return unblockScript.Run(ctx, mq.redis, keys, qm.Key, qm.ID).Err()
+}
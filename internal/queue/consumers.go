@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	consumersKey       = "message_queue:consumers"
+	heartbeatKeyPrefix = "message_queue:heartbeat:"
+	leaseKeyFormat     = "message_queue:shard:%d:owner"
+)
+
+// renewScript extends a shard lease only if it is still held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript drops a shard lease only if it is still held by the caller.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// requeueScript moves one in-flight job back to the consuming end of its
+// shard.
+var requeueScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func leaseKey(shard int) string {
+	return fmt.Sprintf(leaseKeyFormat, shard)
+}
+
+// Heartbeat registers the consumer and marks it alive for ttl. Consumers
+// whose heartbeat expires are treated as crashed by ReapOrphans.
+func (mq *MessageQueue) Heartbeat(ctx context.Context, consumerID string, ttl time.Duration) error {
+	_, err := mq.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, consumersKey, consumerID)
+		pipe.Set(ctx, heartbeatKeyPrefix+consumerID, time.Now().Unix(), ttl)
+		return nil
+	})
+	return err
+}
+
+// ActiveConsumers returns the number of registered consumers across all
+// worker processes.
+func (mq *MessageQueue) ActiveConsumers(ctx context.Context) (int64, error) {
+	return mq.redis.SCard(ctx, consumersKey).Result()
+}
+
+// AcquireShard tries to take the lease on a shard for ttl. Only the lease
+// holder may dequeue from the shard.
+func (mq *MessageQueue) AcquireShard(ctx context.Context, consumerID string, shard int, ttl time.Duration) (bool, error) {
+	return mq.redis.SetNX(ctx, leaseKey(shard), consumerID, ttl).Result()
+}
+
+// RenewShards extends the consumer's leases and returns the shards whose
+// lease it no longer holds.
+func (mq *MessageQueue) RenewShards(ctx context.Context, consumerID string, shards []int, ttl time.Duration) ([]int, error) {
+	var lost []int
+	for _, shard := range shards {
+		renewed, err := renewScript.Run(ctx, mq.redis, []string{leaseKey(shard)}, consumerID, ttl.Milliseconds()).Int()
+		if err != nil {
+			return lost, err
+		}
+		if renewed == 0 {
+			lost = append(lost, shard)
+		}
+	}
+	return lost, nil
+}
+
+// ReleaseShard gives up the consumer's lease on a shard. The consumer must
+// have no jobs from that shard in flight.
+func (mq *MessageQueue) ReleaseShard(ctx context.Context, consumerID string, shard int) error {
+	return releaseScript.Run(ctx, mq.redis, []string{leaseKey(shard)}, consumerID).Err()
+}
+
+// Unregister returns any jobs still held by the consumer to their shards,
+// releases its leases and removes it from the consumer set. It is called on
+// shutdown.
+func (mq *MessageQueue) Unregister(ctx context.Context, consumerID string, shards []int) error {
+	if _, err := mq.requeueProcessing(ctx, consumerID); err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if err := mq.ReleaseShard(ctx, consumerID, shard); err != nil {
+			return err
+		}
+	}
+
+	_, err := mq.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, consumersKey, consumerID)
+		pipe.Del(ctx, heartbeatKeyPrefix+consumerID)
+		return nil
+	})
+	return err
+}
+
+// ReapOrphans re-queues the jobs of consumers whose heartbeat has expired
+// and returns how many jobs were recovered. Consumers call it before they
+// start on a newly acquired shard so a crashed owner's jobs are handled
+// before anything queued after them.
+func (mq *MessageQueue) ReapOrphans(ctx context.Context) (int, error) {
+	consumers, err := mq.redis.SMembers(ctx, consumersKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, consumerID := range consumers {
+		alive, err := mq.redis.Exists(ctx, heartbeatKeyPrefix+consumerID).Result()
+		if err != nil {
+			return recovered, err
+		}
+		if alive > 0 {
+			continue
+		}
+
+		n, err := mq.requeueProcessing(ctx, consumerID)
+		recovered += n
+		if err != nil {
+			return recovered, err
+		}
+
+		if err := mq.redis.SRem(ctx, consumersKey, consumerID).Err(); err != nil {
+			return recovered, err
+		}
+	}
+
+	return recovered, nil
+}
+
+// requeueProcessing moves a consumer's in-flight jobs back to the consuming
+// end of their shards, newest first so the oldest is picked up first.
+func (mq *MessageQueue) requeueProcessing(ctx context.Context, consumerID string) (int, error) {
+	processingKey := processingKeyPrefix + consumerID
+
+	n := 0
+	for {
+		raw, err := mq.redis.LIndex(ctx, processingKey, 0).Result()
+		if err == redis.Nil {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		var qm QueuedMessage
+		if err := json.Unmarshal([]byte(raw), &qm); err != nil {
+			if err := mq.deadLetterRaw(ctx, processingKey, raw, err); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if err := requeueScript.Run(ctx, mq.redis, []string{processingKey, mq.pendingKey(qm.Shard)}, raw).Err(); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// replayScript removes a job from the dead-letter list and, if it was
+// there, pushes its reset copy onto the pending list.
+var replayScript = redis.NewScript(`
+local removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+if removed > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+end
+return removed
+`)
+
+// ListDead returns a page of dead-lettered jobs, most recent first, along
+// with the total number of jobs in the dead-letter list.
+func (mq *MessageQueue) ListDead(ctx context.Context, offset, limit int64) ([]QueuedMessage, int64, error) {
+	total, err := mq.redis.LLen(ctx, deadKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	raws, err := mq.redis.LRange(ctx, deadKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	jobs := make([]QueuedMessage, 0, len(raws))
+	for _, raw := range raws {
+		var qm QueuedMessage
+		if err := json.Unmarshal([]byte(raw), &qm); err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, qm)
+	}
+
+	return jobs, total, nil
+}
+
+// ReplayDead moves the dead-lettered job with the given ID back onto the
+// pending list with its attempt count reset.
+func (mq *MessageQueue) ReplayDead(ctx context.Context, id string) error {
+	qm, err := mq.findDead(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = mq.replay(ctx, qm)
+	return err
+}
+
+// ReplayAllDead replays every dead-lettered job and returns how many were
+// moved back onto the pending list.
+func (mq *MessageQueue) ReplayAllDead(ctx context.Context) (int, error) {
+	raws, err := mq.redis.LRange(ctx, deadKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	// Walk from the oldest failure so jobs are re-queued in their original order
+	for i := len(raws) - 1; i >= 0; i-- {
+		var qm QueuedMessage
+		if err := json.Unmarshal([]byte(raws[i]), &qm); err != nil {
+			return replayed, err
+		}
+		qm.raw = raws[i]
+
+		ok, err := mq.replay(ctx, &qm)
+		if err != nil {
+			return replayed, err
+		}
+		if ok {
+			replayed++
+		}
+	}
+
+	return replayed, nil
+}
+
+// DeleteDead removes a single dead-lettered job.
+func (mq *MessageQueue) DeleteDead(ctx context.Context, id string) error {
+	qm, err := mq.findDead(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return mq.redis.LRem(ctx, deadKey, 1, qm.raw).Err()
+}
+
+// PurgeDead empties the dead-letter list and returns how many jobs it held.
+func (mq *MessageQueue) PurgeDead(ctx context.Context) (int64, error) {
+	var count *redis.IntCmd
+	_, err := mq.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.LLen(ctx, deadKey)
+		pipe.Del(ctx, deadKey)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (mq *MessageQueue) findDead(ctx context.Context, id string) (*QueuedMessage, error) {
+	raws, err := mq.redis.LRange(ctx, deadKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raw := range raws {
+		var qm QueuedMessage
+		if err := json.Unmarshal([]byte(raw), &qm); err != nil {
+			continue
+		}
+		if qm.ID == id {
+			qm.raw = raw
+			return &qm, nil
+		}
+	}
+
+	return nil, ErrJobNotFound
+}
+
+// replay re-queues a dead job. It reports false when the job was no longer
+// in the dead-letter list, e.g. because it was replayed concurrently.
+func (mq *MessageQueue) replay(ctx context.Context, qm *QueuedMessage) (bool, error) {
+	fresh := *qm
+	fresh.Attempts = 0
+	fresh.LastError = ""
+	fresh.FailedAt = nil
+
+	data, err := json.Marshal(fresh)
+	if err != nil {
+		return false, err
+	}
+
+	// Only push the job if this call is the one that removed it
+	removed, err := replayScript.Run(ctx, mq.redis, []string{deadKey, mq.pendingKey(qm.Shard)}, qm.raw, data).Int()
+	if err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
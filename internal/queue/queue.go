@@ -3,32 +3,116 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
+const (
+	// legacyPendingKey is the single pending list used before the queue was
+	// sharded. It is drained into the shards by MigrateLegacy.
+	legacyPendingKey    = "message_queue"
+	pendingKeyPrefix    = "message_queue:shard:"
+	processingKeyPrefix = "message_queue:processing:"
+	delayedKey          = "message_queue:delayed"
+	deadKey             = "message_queue:dead"
+)
+
+const (
+	DefaultShards      = 16
+	DefaultMaxAttempts = 5
+	DefaultBaseBackoff = time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+)
+
+// MessageQueue is a sharded, at-least-once work queue on top of Redis lists.
+// Every job carries a shard key (the chat ID for messages) and all jobs with
+// the same key land on the same shard. A shard is consumed by a single
+// consumer at a time, which keeps jobs for one chat in order.
 type MessageQueue struct {
 	redis *redis.Client
+
+	// Shards is the number of pending lists jobs are spread over. Producers
+	// and consumers must agree on it.
+	Shards int
+	// MaxAttempts is the number of times a job is tried before it is
+	// moved to the dead-letter list.
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry; it doubles on every
+	// further attempt up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
 }
 
 type QueuedMessage struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Key        uint            `json:"key,omitempty"`
+	Shard      int             `json:"shard"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error,omitempty"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+	FailedAt   *time.Time      `json:"failed_at,omitempty"`
+
+	// raw is the exact list element the message was read from, needed to
+	// remove it from the processing list.
+	raw string
 }
 
+var ErrJobNotFound = errors.New("job not found")
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Fail dead-letters the job without retrying it.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// promoteScript moves one delayed job to the consuming end of its shard, if
+// no other worker promoted it first.
+var promoteScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) > 0 then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
 func NewMessageQueue(redis *redis.Client) *MessageQueue {
-	return &MessageQueue{redis: redis}
+	return &MessageQueue{
+		redis:       redis,
+		Shards:      DefaultShards,
+		MaxAttempts: DefaultMaxAttempts,
+		BaseBackoff: DefaultBaseBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+	}
 }
 
-func (mq *MessageQueue) Enqueue(ctx context.Context, msgType string, payload interface{}) error {
+// Enqueue adds a job to the shard that owns key. Jobs enqueued with the same
+// key are processed in the order they were enqueued.
+func (mq *MessageQueue) Enqueue(ctx context.Context, msgType string, key uint, payload interface{}) error {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
 	qm := QueuedMessage{
-		Type:    msgType,
-		Payload: data,
+		ID:         uuid.New().String(),
+		Type:       msgType,
+		Key:        key,
+		Shard:      int(key % uint(mq.Shards)),
+		Payload:    data,
+		EnqueuedAt: time.Now().UTC(),
 	}
 
 	qmJSON, err := json.Marshal(qm)
@@ -36,5 +120,201 @@ func (mq *MessageQueue) Enqueue(ctx context.Context, msgType string, payload int
 		return err
 	}
 
-	return mq.redis.LPush(ctx, "message_queue", qmJSON).Err()
+	return mq.redis.LPush(ctx, mq.pendingKey(qm.Shard), qmJSON).Err()
+}
+
+func (mq *MessageQueue) pendingKey(shard int) string {
+	return fmt.Sprintf("%s%d", pendingKeyPrefix, shard%mq.Shards)
+}
+
+// Dequeue takes the next job from a shard, if any, and atomically moves it
+// to the consumer's processing list, where it stays until it is acked or
+// failed. Jobs whose key is blocked by a pending retry are held back on the
+// way. It returns nil when the shard is empty.
+func (mq *MessageQueue) Dequeue(ctx context.Context, consumerID string, shard int) (*QueuedMessage, error) {
+	processingKey := processingKeyPrefix + consumerID
+
+	keys := []string{mq.pendingKey(shard), processingKey, blockedKey}
+	raw, err := dequeueScript.Run(ctx, mq.redis, keys, heldKeyPrefix).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var qm QueuedMessage
+	if err := json.Unmarshal([]byte(raw), &qm); err != nil {
+		// An undecodable job can never succeed, park it as-is.
+		return nil, mq.deadLetterRaw(ctx, processingKey, raw, err)
+	}
+	qm.raw = raw
+
+	return &qm, nil
+}
+
+// Ack removes a successfully processed job from the processing list. A
+// successful retry releases the jobs held behind it.
+func (mq *MessageQueue) Ack(ctx context.Context, consumerID string, qm *QueuedMessage) error {
+	if err := mq.redis.LRem(ctx, processingKeyPrefix+consumerID, 1, qm.raw).Err(); err != nil {
+		return err
+	}
+	return mq.unblock(ctx, qm)
+}
+
+// AckAll acknowledges several jobs in a single round trip.
+func (mq *MessageQueue) AckAll(ctx context.Context, consumerID string, qms []*QueuedMessage) error {
+	if len(qms) == 0 {
+		return nil
+	}
+
+	_, err := mq.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, qm := range qms {
+			pipe.LRem(ctx, processingKeyPrefix+consumerID, 1, qm.raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, qm := range qms {
+		if err := mq.unblock(ctx, qm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fail records jobErr on the job and either schedules a retry with
+// exponential backoff or, once MaxAttempts is reached or the error is
+// permanent, moves it to the dead-letter list.
+//
+// A scheduled retry blocks the job's key: later jobs with the same key are
+// held until the retry succeeds or is dead-lettered, so jobs for one chat
+// still run in the order they were queued.
+func (mq *MessageQueue) Fail(ctx context.Context, consumerID string, qm *QueuedMessage, jobErr error) error {
+	now := time.Now().UTC()
+
+	failed := *qm
+	failed.Attempts++
+	failed.LastError = jobErr.Error()
+	failed.FailedAt = &now
+
+	data, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+
+	deadLetter := mq.GivesUp(qm, jobErr)
+
+	_, err = mq.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKeyPrefix+consumerID, 1, qm.raw)
+		if deadLetter {
+			pipe.LPush(ctx, deadKey, data)
+		} else {
+			retryAt := now.Add(mq.backoff(failed.Attempts))
+			pipe.ZAdd(ctx, delayedKey, &redis.Z{Score: float64(retryAt.Unix()), Member: data})
+			if qm.Key != 0 {
+				// An earlier retry of the same key keeps the block; this
+				// one is held behind it once promoted
+				pipe.HSetNX(ctx, blockedKey, fmt.Sprint(qm.Key), qm.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil || !deadLetter {
+		return err
+	}
+	return mq.unblock(ctx, qm)
+}
+
+// GivesUp reports whether failing qm with jobErr moves it to the dead-letter
+// list instead of retrying it, because the error is permanent or this was
+// its last attempt.
+func (mq *MessageQueue) GivesUp(qm *QueuedMessage, jobErr error) bool {
+	var permanent *permanentError
+	return errors.As(jobErr, &permanent) || qm.Attempts+1 >= mq.MaxAttempts
+}
+
+func (mq *MessageQueue) backoff(attempts int) time.Duration {
+	delay := mq.BaseBackoff
+	for i := 1; i < attempts && delay < mq.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > mq.MaxBackoff {
+		delay = mq.MaxBackoff
+	}
+	return delay
+}
+
+func (mq *MessageQueue) deadLetterRaw(ctx context.Context, processingKey, raw string, decodeErr error) error {
+	now := time.Now().UTC()
+
+	// Keep the original bytes as a JSON string since they are not valid JSON.
+	payload, _ := json.Marshal(raw)
+	data, err := json.Marshal(QueuedMessage{
+		ID:        uuid.New().String(),
+		Type:      "undecodable",
+		Payload:   payload,
+		Attempts:  1,
+		LastError: decodeErr.Error(),
+		FailedAt:  &now,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = mq.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 1, raw)
+		pipe.LPush(ctx, deadKey, data)
+		return nil
+	})
+	return err
+}
+
+// PromoteDelayed moves retries that are due back onto their shard and
+// returns how many were moved.
+func (mq *MessageQueue) PromoteDelayed(ctx context.Context) (int, error) {
+	due, err := mq.redis.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprintf("%d", time.Now().Unix()),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, raw := range due {
+		var qm QueuedMessage
+		if err := json.Unmarshal([]byte(raw), &qm); err != nil {
+			return promoted, err
+		}
+
+		n, err := promoteScript.Run(ctx, mq.redis, []string{delayedKey, mq.pendingKey(qm.Shard)}, raw).Int()
+		if err != nil {
+			return promoted, err
+		}
+		promoted += n
+	}
+
+	return promoted, nil
+}
+
+// MigrateLegacy moves jobs left on the pre-sharding pending list onto
+// shard 0, keeping their order. It returns how many jobs were moved.
+func (mq *MessageQueue) MigrateLegacy(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		// Newest first onto the consuming end, so the oldest is taken first
+		err := mq.redis.LMove(ctx, legacyPendingKey, mq.pendingKey(0), "LEFT", "RIGHT").Err()
+		if err == redis.Nil {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
 }
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultRoute holds the limit of routes without one of their own.
+const DefaultRoute = "default"
+
+// Names of the routes that have limits or quotas of their own in the
+// default configuration.
+const (
+	RouteApplicationsCreate = "applications.create"
+	RouteChatsCreate        = "chats.create"
+	RouteMessagesCreate     = "messages.create"
+	RouteMessagesSearch     = "messages.search"
+)
+
+// Limit allows Requests per Window.
+type Limit struct {
+	Requests int      `json:"requests"`
+	Window   Duration `json:"window"`
+}
+
+// Routes maps route names to their limit. The DefaultRoute entry applies to
+// every route not listed; without one, unlisted routes are not limited.
+type Routes map[string]Limit
+
+// For returns the limit of a route.
+func (r Routes) For(route string) (Limit, bool) {
+	if limit, ok := r[route]; ok {
+		return limit, true
+	}
+	limit, ok := r[DefaultRoute]
+	return limit, ok
+}
+
+// Plan is what an application's plan entitles it to. DailyMessages caps how
+// many messages it may create per UTC day; zero means no cap.
+type Plan struct {
+	Routes        Routes `json:"routes"`
+	DailyMessages int    `json:"daily_messages"`
+}
+
+// Config holds the limits per client IP, applied to every request, and per
+// plan, applied to requests made with an application token.
+type Config struct {
+	IP          Routes          `json:"ip"`
+	Plans       map[string]Plan `json:"plans"`
+	DefaultPlan string          `json:"default_plan"`
+}
+
+func DefaultConfig() Config {
+	minute := Duration(time.Minute)
+	return Config{
+		IP: Routes{
+			DefaultRoute:            {Requests: 300, Window: minute},
+			RouteApplicationsCreate: {Requests: 10, Window: minute},
+		},
+		Plans: map[string]Plan{
+			"free": {
+				Routes: Routes{
+					DefaultRoute:        {Requests: 120, Window: minute},
+					RouteChatsCreate:    {Requests: 30, Window: minute},
+					RouteMessagesCreate: {Requests: 60, Window: minute},
+					RouteMessagesSearch: {Requests: 30, Window: minute},
+				},
+				DailyMessages: 10000,
+			},
+			"pro": {
+				Routes: Routes{
+					DefaultRoute:        {Requests: 1200, Window: minute},
+					RouteChatsCreate:    {Requests: 300, Window: minute},
+					RouteMessagesCreate: {Requests: 600, Window: minute},
+					RouteMessagesSearch: {Requests: 300, Window: minute},
+				},
+				DailyMessages: 1000000,
+			},
+		},
+		DefaultPlan: "free",
+	}
+}
+
+// ConfigFromEnv loads the JSON file named by RATE_LIMIT_CONFIG, or returns
+// the defaults when it is unset.
+func ConfigFromEnv() (Config, error) {
+	path := os.Getenv("RATE_LIMIT_CONFIG")
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("reading rate limit config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing rate limit config: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid rate limit config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c Config) validate() error {
+	if _, ok := c.Plans[c.DefaultPlan]; !ok {
+		return fmt.Errorf("default plan %q is not defined", c.DefaultPlan)
+	}
+
+	check := func(scope string, routes Routes) error {
+		for route, limit := range routes {
+			if limit.Requests < 1 || limit.Window <= 0 {
+				return fmt.Errorf("%s limit of route %q needs positive requests and window", scope, route)
+			}
+		}
+		return nil
+	}
+	if err := check("IP", c.IP); err != nil {
+		return err
+	}
+	for name, plan := range c.Plans {
+		if err := check("plan "+name, plan.Routes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Plan returns the named plan, or the default plan if it is not defined.
+func (c Config) Plan(name string) Plan {
+	if plan, ok := c.Plans[name]; ok {
+		return plan
+	}
+	return c.Plans[c.DefaultPlan]
+}
+
+// PlanNames returns the defined plans in alphabetical order.
+func (c Config) PlanNames() []string {
+	names := make([]string, 0, len(c.Plans))
+	for name := range c.Plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Duration is a time.Duration written as a string such as "1m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package ratelimit enforces request rate limits and daily message quotas.
+// Counters live in Redis, so every server replica shares them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// windowScript counts a request in a fixed window that starts with its
+// first request, returning the count and the milliseconds left.
+var windowScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// quotaScript takes one unit of a daily quota unless it is used up, so
+// rejected requests do not count against it.
+var quotaScript = redis.NewScript(`
+local used = redis.call('INCR', KEYS[1])
+if used == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+if used > tonumber(ARGV[1]) then
+	used = redis.call('DECR', KEYS[1])
+	return {used, 0}
+end
+return {used, 1}
+`)
+
+// Result describes where a client stands against one limit.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the limit starts over.
+	Reset time.Duration
+
+	// quotaKey is the quota a consumed unit was taken from
+	quotaKey string
+}
+
+type Limiter struct {
+	redis *redis.Client
+}
+
+func NewLimiter(redis *redis.Client) *Limiter {
+	return &Limiter{redis: redis}
+}
+
+// Allow counts a request against limit under key.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	window := time.Duration(limit.Window)
+	res, err := windowScript.Run(ctx, l.redis, []string{"ratelimit:" + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	count := int(res[0])
+	return Result{
+		Allowed:   count <= limit.Requests,
+		Limit:     limit.Requests,
+		Remaining: max(limit.Requests-count, 0),
+		Reset:     time.Duration(res[1]) * time.Millisecond,
+	}, nil
+}
+
+func quotaKey(appID uint, day time.Time) string {
+	return fmt.Sprintf("quota:messages:%d:%s", appID, day.Format("2006-01-02"))
+}
+
+// ConsumeMessage takes one message from an application's daily quota.
+// Quotas reset at midnight UTC.
+func (l *Limiter) ConsumeMessage(ctx context.Context, appID uint, daily int) (Result, error) {
+	now := time.Now().UTC()
+	tomorrow := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	// Keys outlive their day a little so a late refund still finds them
+	ttl := int64(tomorrow.Sub(now).Seconds()) + 3600
+	key := quotaKey(appID, now)
+	res, err := quotaScript.Run(ctx, l.redis, []string{key}, daily, ttl).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:   res[1] == 1,
+		Limit:     daily,
+		Remaining: max(daily-int(res[0]), 0),
+		Reset:     tomorrow.Sub(now),
+		quotaKey:  key,
+	}, nil
+}
+
+// RefundMessage gives back the message ConsumeMessage took for a request
+// that did not create one, to the day it was taken from.
+func (l *Limiter) RefundMessage(ctx context.Context, consumed Result) error {
+	if !consumed.Allowed || consumed.quotaKey == "" {
+		return nil
+	}
+	return l.redis.Decr(ctx, consumed.quotaKey).Err()
+}
@@ -0,0 +1,604 @@
+// Package reindex rebuilds the message search index from MySQL without
+// taking search offline. Messages are copied into a fresh index while the
+// old one keeps serving, writes made in the meantime are caught up, and the
+// alias is then moved over in one step. The package also verifies that the
+// live index still matches MySQL and repairs the drift it finds.
+package reindex
+
+import (
+	"chat-system/internal/jobs"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultBatchSize = 1000
+
+	// JobType is the type of the jobs tracking reindex runs.
+	JobType = "reindex"
+
+	stateKey = "reindex:state"
+	lockKey  = "reindex:lock"
+	lockTTL  = 5 * time.Minute
+
+	// lockRefreshInterval leaves room for a few failed refreshes before
+	// the lock lapses.
+	lockRefreshInterval = lockTTL / 5
+
+	// clockSkew widens every catch-up window so writes stamped by a server
+	// whose clock is a little behind are not missed.
+	clockSkew = time.Minute
+
+	// maxCatchUpPasses bounds how long a busy system can hold off the swap;
+	// whatever is left is picked up after it.
+	maxCatchUpPasses = 10
+
+	// progressEvery is how many batches pass between progress log lines.
+	progressEvery = 20
+)
+
+var (
+	ErrRunning = errors.New("a reindex is already running")
+
+	// ErrClaimLost means the lock lapsed or was taken over while the run
+	// was going on, so the run stopped to leave the index to the new owner.
+	ErrClaimLost = errors.New("the reindex lock was lost")
+)
+
+// refreshLockScript extends the lock only if it still holds the caller's
+// token.
+var refreshLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes the lock only if it still holds the caller's
+// token, so a run that overran its lock cannot release the next run's.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+const (
+	phaseCopy    = "copy"
+	phaseCatchUp = "catch_up"
+)
+
+// state is the checkpoint of a run, saved after every batch so an
+// interrupted run can pick up where it stopped.
+type state struct {
+	JobID string `json:"job_id"`
+	Index string `json:"index"`
+	Phase string `json:"phase"`
+
+	// LastID is the ID of the last message copied.
+	LastID uint `json:"last_id"`
+
+	// CaughtUpTo is the time from which writes still need catching up.
+	CaughtUpTo time.Time `json:"caught_up_to"`
+}
+
+type Options struct {
+	// BatchSize is how many messages are read and indexed at a time.
+	BatchSize int
+	// Restart discards the checkpoint of an interrupted run instead of
+	// resuming it.
+	Restart bool
+	// KeepOld keeps the indices the alias pointed to before the swap,
+	// e.g. to switch back by hand.
+	KeepOld bool
+}
+
+type Reindexer struct {
+	db    *gorm.DB
+	es    *elasticsearch.Client
+	redis *redis.Client
+	jobs  *jobs.Store
+}
+
+func NewReindexer(db *gorm.DB, es *elasticsearch.Client, redis *redis.Client, jobs *jobs.Store) *Reindexer {
+	return &Reindexer{db: db, es: es, redis: redis, jobs: jobs}
+}
+
+// Claim is the exclusive right to run a reindex, handed out by Start. Job
+// tracks the run.
+type Claim struct {
+	Job *jobs.Job
+
+	// token identifies this claim in the lock
+	token string
+}
+
+// Start claims the reindex for this caller and returns the claim with the
+// job that tracks it. An interrupted run is resumed under its original job
+// unless opts.Restart is set. Run must follow, even if only to release the
+// claim.
+func (r *Reindexer) Start(ctx context.Context, opts Options) (*Claim, error) {
+	claim := &Claim{token: uuid.New().String()}
+
+	acquired, err := r.redis.SetNX(ctx, lockKey, claim.token, lockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrRunning
+	}
+
+	claim.Job, err = r.start(ctx, opts)
+	if err != nil {
+		r.release(context.WithoutCancel(ctx), claim)
+		return nil, err
+	}
+	return claim, nil
+}
+
+func (r *Reindexer) start(ctx context.Context, opts Options) (*jobs.Job, error) {
+	st, err := r.loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if st != nil && !opts.Restart {
+		if job, err := r.jobs.Get(ctx, st.JobID); err == nil {
+			return job, nil
+		}
+	}
+	if st != nil {
+		// The half-built index of the abandoned run is of no further use
+		if err := search.DeleteIndex(ctx, r.es, st.Index); err != nil {
+			return nil, err
+		}
+		if err := r.redis.Del(ctx, stateKey).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	index := fmt.Sprintf("%s_%s", search.Index, time.Now().UTC().Format("20060102150405"))
+	job, err := r.jobs.Create(ctx, JobType, index, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	st = &state{JobID: job.ID, Index: index}
+	if err := r.saveState(ctx, st); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run carries out the reindex claimed by Start. The lock is kept alive for
+// as long as the run takes and released at the end. Progress is recorded
+// on the job; a failed run can be resumed by starting again.
+func (r *Reindexer) Run(ctx context.Context, claim *Claim, opts Options) error {
+	defer r.release(context.WithoutCancel(ctx), claim)
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go r.keepClaim(runCtx, claim, cancel)
+
+	err := r.run(runCtx, opts)
+	if cause := context.Cause(runCtx); errors.Is(cause, ErrClaimLost) {
+		return cause
+	}
+	return err
+}
+
+// keepClaim refreshes the lock until ctx is done. If the lock no longer
+// holds the claim's token, it cancels the run.
+func (r *Reindexer) keepClaim(ctx context.Context, claim *Claim, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		held, err := refreshLockScript.Run(ctx, r.redis, []string{lockKey}, claim.token, lockTTL.Milliseconds()).Int()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error refreshing the reindex lock: %v", err)
+			}
+			continue
+		}
+		if held == 0 {
+			cancel(ErrClaimLost)
+			return
+		}
+	}
+}
+
+// release gives up the lock if it still belongs to claim.
+func (r *Reindexer) release(ctx context.Context, claim *Claim) {
+	if err := releaseLockScript.Run(ctx, r.redis, []string{lockKey}, claim.token).Err(); err != nil {
+		log.Printf("Error releasing the reindex lock: %v", err)
+	}
+}
+
+func (r *Reindexer) run(ctx context.Context, opts Options) (err error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	st, err := r.loadState(ctx)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return errors.New("no reindex has been started")
+	}
+
+	defer func() {
+		// A run that lost its claim leaves the job to whoever took over
+		if err != nil && !errors.Is(context.Cause(ctx), ErrClaimLost) {
+			if setErr := r.jobs.SetStatus(context.WithoutCancel(ctx), st.JobID, jobs.StatusFailed, err); setErr != nil {
+				log.Printf("Error recording failure of reindex job %s: %v", st.JobID, setErr)
+			}
+		}
+	}()
+	if err := r.jobs.SetStatus(ctx, st.JobID, jobs.StatusRunning, nil); err != nil {
+		return err
+	}
+
+	if st.Phase == "" {
+		if err := r.prepare(ctx, st); err != nil {
+			return err
+		}
+	}
+	if st.Phase == phaseCopy {
+		if err := r.copyMessages(ctx, st, opts.BatchSize); err != nil {
+			return err
+		}
+	}
+	if err := r.catchUpAndSwap(ctx, st, opts); err != nil {
+		return err
+	}
+
+	if err := r.redis.Del(ctx, stateKey).Err(); err != nil {
+		return err
+	}
+	log.Printf("Reindex into %s completed", st.Index)
+	return r.jobs.SetStatus(ctx, st.JobID, jobs.StatusCompleted, nil)
+}
+
+// prepare creates the new index, tuned for bulk loading, and records when
+// copying started so writes from then on are caught up afterwards.
+func (r *Reindexer) prepare(ctx context.Context, st *state) error {
+	if err := search.CreateIndex(ctx, r.es, st.Index); err != nil {
+		return err
+	}
+	if err := search.UpdateSettings(ctx, r.es, st.Index, map[string]interface{}{
+		"index.refresh_interval": "-1",
+	}); err != nil {
+		return err
+	}
+
+	var total int64
+	if err := r.db.WithContext(ctx).Table("messages").Where("deleted_at IS NULL").Count(&total).Error; err != nil {
+		return fmt.Errorf("counting messages: %w", err)
+	}
+	if err := r.jobs.SetCounter(ctx, st.JobID, "messages_total", total); err != nil {
+		return err
+	}
+
+	st.Phase = phaseCopy
+	st.CaughtUpTo = time.Now().UTC().Add(-clockSkew)
+	return r.saveState(ctx, st)
+}
+
+// copyMessages streams every live message in ID order into the new index.
+func (r *Reindexer) copyMessages(ctx context.Context, st *state, batchSize int) error {
+	for batch := 1; ; batch++ {
+		rows, err := messageRows(ctx, r.db, batchSize, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("messages.id > ?", st.LastID).
+				Where("messages.deleted_at IS NULL AND chats.deleted_at IS NULL")
+		})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		if err := search.Bulk(ctx, r.es, st.Index, documents(rows), nil); err != nil {
+			return fmt.Errorf("indexing messages after ID %d: %w", st.LastID, err)
+		}
+
+		st.LastID = rows[len(rows)-1].ID
+		if err := r.saveState(ctx, st); err != nil {
+			return err
+		}
+		indexed, err := r.jobs.IncrCounter(ctx, st.JobID, "messages_indexed", int64(len(rows)))
+		if err != nil {
+			return err
+		}
+		if batch%progressEvery == 0 {
+			log.Printf("Reindex into %s: %d messages indexed, up to ID %d", st.Index, indexed, st.LastID)
+		}
+	}
+
+	st.Phase = phaseCatchUp
+	return r.saveState(ctx, st)
+}
+
+// catchUpAndSwap applies writes made since copying started until few are
+// left, points the alias at the new index, and then applies the writes that
+// still went to the old index in between.
+func (r *Reindexer) catchUpAndSwap(ctx context.Context, st *state, opts Options) error {
+	for pass := 0; pass < maxCatchUpPasses; pass++ {
+		changed, err := r.catchUp(ctx, st, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if changed < int64(opts.BatchSize) {
+			break
+		}
+	}
+
+	if err := search.UpdateSettings(ctx, r.es, st.Index, map[string]interface{}{
+		"index.refresh_interval": nil,
+	}); err != nil {
+		return err
+	}
+	if err := search.Refresh(ctx, r.es, st.Index); err != nil {
+		return err
+	}
+
+	old, err := r.swap(ctx, st.Index)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.catchUp(ctx, st, opts.BatchSize); err != nil {
+		return err
+	}
+	if err := r.deleteOrphans(ctx, st); err != nil {
+		return err
+	}
+
+	if opts.KeepOld {
+		return nil
+	}
+	for _, index := range old {
+		if err := search.DeleteIndex(ctx, r.es, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swap moves the alias from whatever it points to onto index in a single
+// atomic update and returns the indices it pointed to before.
+func (r *Reindexer) swap(ctx context.Context, index string) ([]string, error) {
+	current, err := search.AliasIndices(ctx, r.es)
+	if err != nil {
+		return nil, err
+	}
+
+	var old []string
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": index, "alias": search.Alias}},
+	}
+	for _, name := range current {
+		if name == index {
+			continue
+		}
+		old = append(old, name)
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{"index": name, "alias": search.Alias},
+		})
+	}
+
+	// A legacy index named like the alias has to go for the alias to exist
+	legacy, err := search.IndexExists(ctx, r.es, search.Alias)
+	if err != nil {
+		return nil, err
+	}
+	if legacy && len(current) == 0 {
+		actions = append(actions, map[string]interface{}{
+			"remove_index": map[string]interface{}{"index": search.Alias},
+		})
+	}
+
+	if err := search.UpdateAliases(ctx, r.es, actions); err != nil {
+		return nil, err
+	}
+	log.Printf("Alias %s now points to %s", search.Alias, index)
+	return old, nil
+}
+
+// catchUp brings the new index up to date with messages written, edited or
+// deleted and chats deleted since the last pass. It returns how many
+// changes it applied.
+func (r *Reindexer) catchUp(ctx context.Context, st *state, batchSize int) (int64, error) {
+	since := st.CaughtUpTo
+	next := time.Now().UTC().Add(-clockSkew)
+
+	var changed int64
+	for _, column := range []string{"messages.updated_at", "messages.deleted_at"} {
+		var afterID uint
+		for {
+			rows, err := messageRows(ctx, r.db, batchSize, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where(column+" >= ? AND messages.id > ?", since, afterID)
+			})
+			if err != nil {
+				return changed, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+
+			var live []messageRow
+			var gone []string
+			for _, row := range rows {
+				if row.DeletedAt != nil || row.ChatDeletedAt != nil {
+					gone = append(gone, search.DocumentID(row.ChatID, row.MessageNumber))
+				} else {
+					live = append(live, row)
+				}
+			}
+			if err := search.Bulk(ctx, r.es, st.Index, documents(live), gone); err != nil {
+				return changed, fmt.Errorf("catching up messages: %w", err)
+			}
+
+			changed += int64(len(rows))
+			afterID = rows[len(rows)-1].ID
+		}
+	}
+
+	var deletedChats []uint
+	if err := r.db.WithContext(ctx).Table("chats").
+		Where("deleted_at >= ?", since).
+		Pluck("id", &deletedChats).Error; err != nil {
+		return changed, fmt.Errorf("listing deleted chats: %w", err)
+	}
+	if _, err := search.DeleteChats(ctx, r.es, st.Index, deletedChats); err != nil {
+		return changed, err
+	}
+	changed += int64(len(deletedChats))
+
+	if _, err := r.jobs.IncrCounter(ctx, st.JobID, "changes_caught_up", changed); err != nil {
+		return changed, err
+	}
+
+	st.CaughtUpTo = next
+	return changed, r.saveState(ctx, st)
+}
+
+// deleteOrphans removes documents of chats that no longer exist, such as
+// chats whose deletion finished while the index was being built.
+func (r *Reindexer) deleteOrphans(ctx context.Context, st *state) error {
+	return search.EachChatID(ctx, r.es, st.Index, DefaultBatchSize, func(chatIDs []uint) error {
+		orphans, err := orphanedChats(ctx, r.db, chatIDs, time.Now())
+		if err != nil {
+			return err
+		}
+		deleted, err := search.DeleteChats(ctx, r.es, st.Index, orphans)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			if _, err := r.jobs.IncrCounter(ctx, st.JobID, "orphans_deleted", deleted); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// messageRow is a message together with the chat fields its document needs.
+type messageRow struct {
+	ID            uint
+	ChatID        uint
+	MessageNumber int
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	EditedAt      *time.Time
+	DeletedAt     *time.Time
+	ApplicationID uint
+	ChatNumber    int
+	ChatDeletedAt *time.Time
+}
+
+func messageRows(ctx context.Context, db *gorm.DB, limit int, scope func(*gorm.DB) *gorm.DB) ([]messageRow, error) {
+	var rows []messageRow
+	err := scope(db.WithContext(ctx).Table("messages").
+		Select("messages.id, messages.chat_id, messages.message_number, messages.body, " +
+			"messages.created_at, messages.updated_at, messages.edited_at, messages.deleted_at, " +
+			"chats.application_id, chats.chat_number, chats.deleted_at AS chat_deleted_at").
+		Joins("JOIN chats ON chats.id = messages.chat_id")).
+		Order("messages.id").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("reading messages: %w", err)
+	}
+	return rows, nil
+}
+
+func documents(rows []messageRow) []search.Document {
+	docs := make([]search.Document, len(rows))
+	for i, row := range rows {
+		docs[i] = search.Document{
+			ApplicationID: row.ApplicationID,
+			ChatID:        row.ChatID,
+			ChatNumber:    row.ChatNumber,
+			MessageNumber: row.MessageNumber,
+			Body:          row.Body,
+			CreatedAt:     row.CreatedAt,
+			UpdatedAt:     row.UpdatedAt,
+			EditedAt:      row.EditedAt,
+		}
+	}
+	return docs
+}
+
+// orphanedChats returns the chats among chatIDs that do not exist or were
+// deleted before the given time.
+func orphanedChats(ctx context.Context, db *gorm.DB, chatIDs []uint, deletedBefore time.Time) ([]uint, error) {
+	var existing []uint
+	if err := db.WithContext(ctx).Table("chats").
+		Where("id IN ? AND (deleted_at IS NULL OR deleted_at >= ?)", chatIDs, deletedBefore).
+		Pluck("id", &existing).Error; err != nil {
+		return nil, fmt.Errorf("checking chats: %w", err)
+	}
+	return missing(chatIDs, existing), nil
+}
+
+// missing returns the IDs in all that are not in present.
+func missing(all, present []uint) []uint {
+	seen := make(map[uint]bool, len(present))
+	for _, id := range present {
+		seen[id] = true
+	}
+
+	var result []uint
+	for _, id := range all {
+		if !seen[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func (r *Reindexer) loadState(ctx context.Context) (*state, error) {
+	data, err := r.redis.Get(ctx, stateKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("decoding reindex checkpoint: %w", err)
+	}
+	return &st, nil
+}
+
+// saveState stores the checkpoint.
+func (r *Reindexer) saveState(ctx context.Context, st *state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(ctx, stateKey, data, 0).Err()
+}
@@ -0,0 +1,484 @@
+package reindex
+
+import (
+	"chat-system/internal/logger"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSampleSize = 1000
+
+	// MaxSampleSize is the most documents the index hands out in one page.
+	MaxSampleSize = 10000
+
+	verifyLockKey = "verify:lock"
+	verifyLockTTL = 10 * time.Minute
+	reportKey     = "verify:report"
+
+	// settleTime keeps recent writes out of the comparison. The worker
+	// writes MySQL before the index, so a message written a moment ago may
+	// simply not have reached the index yet.
+	settleTime = 5 * time.Minute
+
+	// staleAfter is how far a document's updated_at may trail its message
+	// before it counts as stale. MySQL keeps milliseconds while documents
+	// carry the time the worker had in memory.
+	staleAfter = time.Second
+)
+
+var ErrVerifying = errors.New("a consistency check is already running")
+
+// errVerifyLockLost stops a check whose lock lapsed, leaving the index to
+// the check that took over.
+var errVerifyLockLost = errors.New("the consistency check lock was lost")
+
+type VerifyOptions struct {
+	// BatchSize is how many chats, messages or documents are compared at a
+	// time.
+	BatchSize int
+	// SampleSize is how many messages, and as many documents, are picked at
+	// random and looked up on the other side. It catches drift that leaves
+	// a chat's counts equal, e.g. one document missing and another orphaned.
+	SampleSize int
+	// Repair indexes missing and stale messages and deletes orphaned
+	// documents instead of only reporting them.
+	Repair bool
+}
+
+// Report describes what a consistency check found. Missing messages are in
+// MySQL but not the index, orphaned documents are in the index but their
+// message or chat is gone, and stale documents hold an older version of
+// their message.
+type Report struct {
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	Repair           bool      `json:"repair"`
+	ChatsChecked     int64     `json:"chats_checked"`
+	ChatsDrifted     int64     `json:"chats_drifted"`
+	OrphanedChats    int64     `json:"orphaned_chats"`
+	MessagesSampled  int64     `json:"messages_sampled"`
+	DocumentsSampled int64     `json:"documents_sampled"`
+	Missing          int64     `json:"missing"`
+	Stale            int64     `json:"stale"`
+	Orphaned         int64     `json:"orphaned"`
+	Repaired         int64     `json:"repaired"`
+}
+
+// Verifier compares the search index with MySQL. Every live chat's message
+// count is checked against its document count, and chats that disagree are
+// compared message by message. On top of that a random sample of messages
+// and documents is looked up on the other side.
+type Verifier struct {
+	db    *gorm.DB
+	es    *elasticsearch.Client
+	redis *redis.Client
+}
+
+func NewVerifier(db *gorm.DB, es *elasticsearch.Client, redis *redis.Client) *Verifier {
+	return &Verifier{db: db, es: es, redis: redis}
+}
+
+// Verify runs one consistency check and records its report. Only one check
+// runs at a time across all processes, and none while a reindex is moving
+// the alias.
+func (v *Verifier) Verify(ctx context.Context, opts VerifyOptions) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.SampleSize < 0 {
+		opts.SampleSize = 0
+	}
+	if opts.SampleSize > MaxSampleSize {
+		opts.SampleSize = MaxSampleSize
+	}
+
+	// The token keeps a check that overran its lock from releasing or
+	// extending the next check's
+	token := uuid.New().String()
+	acquired, err := v.redis.SetNX(ctx, verifyLockKey, token, verifyLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrVerifying
+	}
+	defer releaseLockScript.Run(context.WithoutCancel(ctx), v.redis, []string{verifyLockKey}, token)
+
+	reindexing, err := v.redis.Exists(ctx, lockKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if reindexing > 0 {
+		return nil, ErrRunning
+	}
+
+	run := &verification{
+		Verifier: v,
+		opts:     opts,
+		token:    token,
+		cutoff:   time.Now().UTC().Add(-settleTime),
+		seen:     make(map[string]bool),
+		report:   &Report{StartedAt: time.Now().UTC(), Repair: opts.Repair},
+	}
+	for _, step := range []func(context.Context) error{
+		run.compareChats,
+		run.findOrphanedChats,
+		run.sampleMessages,
+		run.sampleDocuments,
+	} {
+		if err := step(ctx); err != nil {
+			return nil, err
+		}
+	}
+	run.report.FinishedAt = time.Now().UTC()
+
+	if err := v.saveReport(ctx, run.report); err != nil {
+		return nil, err
+	}
+	logger.Info(ctx, "Search index consistency check finished", run.report)
+	return run.report, nil
+}
+
+// LastReport returns the report of the most recent check, or nil if none
+// has finished yet.
+func (v *Verifier) LastReport(ctx context.Context) (*Report, error) {
+	data, err := v.redis.Get(ctx, reportKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("decoding consistency report: %w", err)
+	}
+	return &report, nil
+}
+
+func (v *Verifier) saveReport(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return v.redis.Set(ctx, reportKey, data, 0).Err()
+}
+
+// verification is the state of one check.
+type verification struct {
+	*Verifier
+	opts   VerifyOptions
+	token  string
+	cutoff time.Time
+	report *Report
+
+	// seen holds the IDs of documents already reported, so one found both
+	// by the chat comparison and a sample is counted once.
+	seen map[string]bool
+}
+
+// compareChats checks the message count of every live chat against its
+// document count, and compares chats that disagree in full.
+func (run *verification) compareChats(ctx context.Context) error {
+	var afterID uint
+	for {
+		var chatIDs []uint
+		if err := run.db.WithContext(ctx).Table("chats").
+			Where("id > ? AND deleted_at IS NULL", afterID).
+			Order("id").
+			Limit(run.opts.BatchSize).
+			Pluck("id", &chatIDs).Error; err != nil {
+			return fmt.Errorf("listing chats: %w", err)
+		}
+		if len(chatIDs) == 0 {
+			return nil
+		}
+
+		var rows []struct {
+			ChatID uint
+			Count  int64
+		}
+		if err := run.db.WithContext(ctx).Table("messages").
+			Select("chat_id, COUNT(*) AS count").
+			Where("chat_id IN ? AND deleted_at IS NULL AND updated_at < ?", chatIDs, run.cutoff).
+			Group("chat_id").
+			Scan(&rows).Error; err != nil {
+			return fmt.Errorf("counting messages: %w", err)
+		}
+		counts := make(map[uint]int64, len(rows))
+		for _, row := range rows {
+			counts[row.ChatID] = row.Count
+		}
+
+		indexed, err := search.CountByChat(ctx, run.es, search.Alias, chatIDs, run.cutoff)
+		if err != nil {
+			return err
+		}
+
+		for _, chatID := range chatIDs {
+			if counts[chatID] == indexed[chatID] {
+				continue
+			}
+			if err := run.compareChat(ctx, chatID, counts[chatID], indexed[chatID]); err != nil {
+				return err
+			}
+		}
+
+		run.report.ChatsChecked += int64(len(chatIDs))
+		afterID = chatIDs[len(chatIDs)-1]
+		held, err := refreshLockScript.Run(ctx, run.redis, []string{verifyLockKey}, run.token, verifyLockTTL.Milliseconds()).Int()
+		if err != nil {
+			return err
+		}
+		if held == 0 {
+			return errVerifyLockLost
+		}
+	}
+}
+
+// compareChat compares a chat's messages with its documents a range of
+// message numbers at a time.
+func (run *verification) compareChat(ctx context.Context, chatID uint, count, indexed int64) error {
+	before := *run.report
+	after := 0
+	for {
+		rows, err := messageRows(ctx, run.db, run.opts.BatchSize, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("messages.chat_id = ? AND messages.message_number > ?", chatID, after).
+				Order("messages.message_number")
+		})
+		if err != nil {
+			return err
+		}
+
+		// The last range is open so documents beyond the last message are
+		// found too
+		upTo := math.MaxInt32
+		if len(rows) == run.opts.BatchSize {
+			upTo = rows[len(rows)-1].MessageNumber
+		}
+
+		docs, err := search.ChatDocuments(ctx, run.es, search.Alias, chatID, after, upTo, run.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if err := run.reconcile(ctx, rows, docs); err != nil {
+			return err
+		}
+
+		if upTo == math.MaxInt32 {
+			break
+		}
+		after = upTo
+	}
+
+	// Counts also differ while an edit is on its way to the index, so only
+	// chats with actual differences count as drifted
+	missing := run.report.Missing - before.Missing
+	stale := run.report.Stale - before.Stale
+	orphaned := run.report.Orphaned - before.Orphaned
+	if missing+stale+orphaned > 0 {
+		run.report.ChatsDrifted++
+		logger.Info(ctx, "Chat drifted from the search index", map[string]interface{}{
+			"chat_id":   chatID,
+			"messages":  count,
+			"documents": indexed,
+			"missing":   missing,
+			"stale":     stale,
+			"orphaned":  orphaned,
+		})
+	}
+	return nil
+}
+
+// findOrphanedChats looks for documents of chats that no longer exist.
+func (run *verification) findOrphanedChats(ctx context.Context) error {
+	return search.EachChatID(ctx, run.es, search.Alias, run.opts.BatchSize, func(chatIDs []uint) error {
+		orphans, err := orphanedChats(ctx, run.db, chatIDs, run.cutoff)
+		if err != nil {
+			return err
+		}
+		if len(orphans) == 0 {
+			return nil
+		}
+
+		run.report.OrphanedChats += int64(len(orphans))
+		logger.Info(ctx, "Search index holds documents of deleted chats", map[string]interface{}{"chat_ids": orphans})
+		if !run.opts.Repair {
+			return nil
+		}
+
+		deleted, err := search.DeleteChats(ctx, run.es, search.Alias, orphans)
+		if err != nil {
+			return err
+		}
+		run.report.Repaired += deleted
+		return nil
+	})
+}
+
+// sampleMessages looks up randomly picked messages in the index.
+func (run *verification) sampleMessages(ctx context.Context) error {
+	if run.opts.SampleSize == 0 {
+		return nil
+	}
+
+	var bounds struct {
+		FirstID uint
+		LastID  uint
+	}
+	if err := run.db.WithContext(ctx).Table("messages").
+		Select("MIN(id) AS first_id, MAX(id) AS last_id").
+		Scan(&bounds).Error; err != nil {
+		return fmt.Errorf("reading message IDs: %w", err)
+	}
+	if bounds.LastID == 0 {
+		return nil
+	}
+
+	// IDs are allocated in order, so a random ID in range is nearly always
+	// a message
+	ids := make([]uint, run.opts.SampleSize)
+	for i := range ids {
+		ids[i] = bounds.FirstID + uint(rand.Int63n(int64(bounds.LastID-bounds.FirstID)+1))
+	}
+
+	for start := 0; start < len(ids); start += run.opts.BatchSize {
+		batch := ids[start:min(start+run.opts.BatchSize, len(ids))]
+		rows, err := messageRows(ctx, run.db, len(batch), func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("messages.id IN ?", batch)
+		})
+		if err != nil {
+			return err
+		}
+
+		docIDs := make([]string, len(rows))
+		for i, row := range rows {
+			docIDs[i] = search.DocumentID(row.ChatID, row.MessageNumber)
+		}
+		found, err := search.GetDocuments(ctx, run.es, search.Alias, docIDs)
+		if err != nil {
+			return err
+		}
+
+		docs := make([]search.Document, 0, len(found))
+		for _, doc := range found {
+			docs = append(docs, doc)
+		}
+		if err := run.reconcile(ctx, rows, docs); err != nil {
+			return err
+		}
+		run.report.MessagesSampled += int64(len(rows))
+	}
+	return nil
+}
+
+// sampleDocuments looks up the messages of randomly picked documents.
+func (run *verification) sampleDocuments(ctx context.Context) error {
+	if run.opts.SampleSize == 0 {
+		return nil
+	}
+
+	docs, err := search.SampleDocuments(ctx, run.es, search.Alias, run.opts.SampleSize, run.cutoff)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(docs); start += run.opts.BatchSize {
+		batch := docs[start:min(start+run.opts.BatchSize, len(docs))]
+		keys := make([][]interface{}, len(batch))
+		for i, doc := range batch {
+			keys[i] = []interface{}{doc.ChatID, doc.MessageNumber}
+		}
+
+		rows, err := messageRows(ctx, run.db, len(batch), func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("(messages.chat_id, messages.message_number) IN ?", keys)
+		})
+		if err != nil {
+			return err
+		}
+		if err := run.reconcile(ctx, rows, batch); err != nil {
+			return err
+		}
+		run.report.DocumentsSampled += int64(len(batch))
+	}
+	return nil
+}
+
+// reconcile compares messages with the documents found for them. Documents
+// without a message in rows count as orphaned, so callers pass documents
+// from the same range of messages. Anything changed after the cutoff is
+// left for the next check.
+func (run *verification) reconcile(ctx context.Context, rows []messageRow, docs []search.Document) error {
+	indexed := make(map[string]search.Document, len(docs))
+	for _, doc := range docs {
+		indexed[doc.ID()] = doc
+	}
+
+	var reindex []messageRow
+	var orphans []string
+	for _, row := range rows {
+		id := search.DocumentID(row.ChatID, row.MessageNumber)
+		doc, found := indexed[id]
+		delete(indexed, id)
+
+		switch {
+		case row.DeletedAt != nil || row.ChatDeletedAt != nil:
+			if found && run.settled(row.DeletedAt) && run.settled(row.ChatDeletedAt) {
+				orphans = append(orphans, id)
+				run.record(id, &run.report.Orphaned)
+			}
+		case !row.UpdatedAt.Before(run.cutoff):
+		case !found:
+			reindex = append(reindex, row)
+			run.record(id, &run.report.Missing)
+		case row.UpdatedAt.Sub(doc.UpdatedAt) > staleAfter:
+			reindex = append(reindex, row)
+			run.record(id, &run.report.Stale)
+		}
+	}
+	for id, doc := range indexed {
+		// The message may have been stored after its rows were read
+		if !doc.UpdatedAt.Before(run.cutoff) {
+			continue
+		}
+		orphans = append(orphans, id)
+		run.record(id, &run.report.Orphaned)
+	}
+
+	if !run.opts.Repair || (len(reindex) == 0 && len(orphans) == 0) {
+		return nil
+	}
+	if err := search.Bulk(ctx, run.es, search.Alias, documents(reindex), orphans); err != nil {
+		return fmt.Errorf("repairing the search index: %w", err)
+	}
+	run.report.Repaired += int64(len(reindex) + len(orphans))
+	return nil
+}
+
+// settled reports whether a deletion happened before the cutoff, or did not
+// happen at all.
+func (run *verification) settled(deletedAt *time.Time) bool {
+	return deletedAt == nil || deletedAt.Before(run.cutoff)
+}
+
+// record counts a difference the first time a document shows up in it.
+func (run *verification) record(id string, counter *int64) {
+	if run.seen[id] {
+		return
+	}
+	run.seen[id] = true
+	*counter++
+}
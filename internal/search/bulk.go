@@ -0,0 +1,181 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Bulk writes documents to index and deletes the documents with the given
+// IDs in one request. Deleting a document that does not exist is not an
+// error.
+func Bulk(ctx context.Context, es *elasticsearch.Client, index string, documents []Document, deletes []string) error {
+	if len(documents) == 0 && len(deletes) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, document := range documents {
+		meta := map[string]interface{}{"index": map[string]interface{}{"_index": index, "_id": document.ID()}}
+		if err := encoder.Encode(meta); err != nil {
+			return err
+		}
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+	for _, id := range deletes {
+		meta := map[string]interface{}{"delete": map[string]interface{}{"_index": index, "_id": id}}
+		if err := encoder.Encode(meta); err != nil {
+			return err
+		}
+	}
+
+	res, err := es.Bulk(&body, es.Bulk.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("bulk request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("bulk request: %s", res.String())
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			if outcome.Status < 300 || (action == "delete" && outcome.Status == http.StatusNotFound) {
+				continue
+			}
+			return fmt.Errorf("bulk %s of document %s failed: %s", action, outcome.ID, outcome.Error)
+		}
+	}
+	return nil
+}
+
+// EachChatID calls fn with the IDs of the chats that have documents in
+// index, a page at a time in ascending order.
+func EachChatID(ctx context.Context, es *elasticsearch.Client, index string, pageSize int, fn func(chatIDs []uint) error) error {
+	var after map[string]interface{}
+	for {
+		composite := map[string]interface{}{
+			"size":    pageSize,
+			"sources": []interface{}{map[string]interface{}{"chat_id": map[string]interface{}{"terms": map[string]interface{}{"field": "chat_id"}}}},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		query, err := json.Marshal(map[string]interface{}{
+			"size": 0,
+			"aggs": map[string]interface{}{"chats": map[string]interface{}{"composite": composite}},
+		})
+		if err != nil {
+			return err
+		}
+
+		res, err := es.Search(
+			es.Search.WithContext(ctx),
+			es.Search.WithIndex(index),
+			es.Search.WithBody(bytes.NewReader(query)),
+		)
+		if err != nil {
+			return fmt.Errorf("listing chats in %s: %w", index, err)
+		}
+
+		var result struct {
+			Aggregations struct {
+				Chats struct {
+					AfterKey map[string]interface{} `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							ChatID uint `json:"chat_id"`
+						} `json:"key"`
+					} `json:"buckets"`
+				} `json:"chats"`
+			} `json:"aggregations"`
+		}
+		if res.IsError() {
+			err = fmt.Errorf("listing chats in %s: %s", index, res.String())
+		} else {
+			err = json.NewDecoder(res.Body).Decode(&result)
+		}
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		buckets := result.Aggregations.Chats.Buckets
+		if len(buckets) == 0 {
+			return nil
+		}
+
+		chatIDs := make([]uint, len(buckets))
+		for i, bucket := range buckets {
+			chatIDs[i] = bucket.Key.ChatID
+		}
+		if err := fn(chatIDs); err != nil {
+			return err
+		}
+
+		if len(buckets) < pageSize || result.Aggregations.Chats.AfterKey == nil {
+			return nil
+		}
+		after = result.Aggregations.Chats.AfterKey
+	}
+}
+
+// DeleteChats removes every document of the given chats from index and
+// returns how many were deleted.
+func DeleteChats(ctx context.Context, es *elasticsearch.Client, index string, chatIDs []uint) (int64, error) {
+	if len(chatIDs) == 0 {
+		return 0, nil
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"terms": map[string]interface{}{"chat_id": chatIDs}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := es.DeleteByQuery([]string{index}, strings.NewReader(string(query)),
+		es.DeleteByQuery.WithContext(ctx),
+		es.DeleteByQuery.WithConflicts("proceed"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("deleting chat documents from %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("deleting chat documents from %s: %s", index, res.String())
+	}
+
+	var result struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("decoding delete response: %w", err)
+	}
+	return result.Deleted, nil
+}
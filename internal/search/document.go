@@ -0,0 +1,58 @@
+// Package search defines how messages are stored in Elasticsearch: the
+// document written for every message and the index that holds them.
+package search
+
+import (
+	"chat-system/internal/db/models"
+	"fmt"
+	"time"
+)
+
+// Document is the search representation of a message. Messages are
+// scoped by application ID rather than token, since tokens rotate and are
+// credentials that have no place in the search cluster.
+type Document struct {
+	ApplicationID uint       `json:"application_id"`
+	ChatID        uint       `json:"chat_id"`
+	ChatNumber    int        `json:"chat_number"`
+	MessageNumber int        `json:"message_number"`
+	Body          string     `json:"body"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+}
+
+// NewDocument builds the document of a message in chat.
+func NewDocument(message models.Message, chat models.Chat) Document {
+	return Document{
+		ApplicationID: chat.ApplicationID,
+		ChatID:        message.ChatID,
+		ChatNumber:    chat.ChatNumber,
+		MessageNumber: message.MessageNumber,
+		Body:          message.Body,
+		CreatedAt:     message.CreatedAt,
+		UpdatedAt:     message.UpdatedAt,
+		EditedAt:      message.EditedAt,
+	}
+}
+
+// ID is the document's ID, unique per message.
+func (d Document) ID() string {
+	return DocumentID(d.ChatID, d.MessageNumber)
+}
+
+// Message returns the message a document was built from.
+func (d Document) Message() models.Message {
+	return models.Message{
+		ChatID:        d.ChatID,
+		MessageNumber: d.MessageNumber,
+		Body:          d.Body,
+		CreatedAt:     d.CreatedAt,
+		UpdatedAt:     d.UpdatedAt,
+		EditedAt:      d.EditedAt,
+	}
+}
+
+func DocumentID(chatID uint, messageNumber int) string {
+	return fmt.Sprintf("%d-%d", chatID, messageNumber)
+}
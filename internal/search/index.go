@@ -0,0 +1,334 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	// Alias is the name all reads and writes go through, so the physical
+	// index behind it can be replaced without touching clients.
+	Alias = "messages"
+
+	// Index is the physical index created for the current mapping.
+	Index = "messages_v3"
+
+	// BodyPrefix holds the leading n-grams of every word of the body, so a
+	// query for the start of a word finds the whole word.
+	BodyPrefix = "body.prefix"
+
+	// BodyInfix holds the n-grams from anywhere inside every word of the
+	// body, for queries that match in the middle of a word.
+	BodyInfix = "body.infix"
+)
+
+// ErrOutdatedMapping means an index maps every document field but lacks
+// subfields added since it was created. It still serves documents, but
+// only a reindex enables everything searches rely on.
+var ErrOutdatedMapping = errors.New("index mapping is outdated")
+
+// fieldTypes are the document fields and their Elasticsearch types.
+var fieldTypes = map[string]string{
+	"application_id": "long",
+	"chat_id":        "long",
+	"chat_number":    "integer",
+	"message_number": "integer",
+	"body":           "text",
+	"created_at":     "date",
+	"updated_at":     "date",
+	"edited_at":      "date",
+}
+
+// IndexBody is the body of the request creating an index for Document.
+// The mapping is strict, so a document with fields it does not declare is
+// rejected rather than silently mapped. Besides the standard analyzed body,
+// n-gram subfields back prefix and substring search.
+func IndexBody() string {
+	properties := make(map[string]interface{}, len(fieldTypes))
+	for field, fieldType := range fieldTypes {
+		properties[field] = map[string]interface{}{"type": fieldType}
+	}
+	properties["body"] = map[string]interface{}{
+		"type":     "text",
+		"analyzer": "standard",
+		"fields": map[string]interface{}{
+			"prefix": map[string]interface{}{"type": "text", "analyzer": "body_prefix", "search_analyzer": "standard"},
+			"infix":  map[string]interface{}{"type": "text", "analyzer": "body_infix"},
+		},
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"settings": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"filter": map[string]interface{}{
+					"body_prefix": map[string]interface{}{"type": "edge_ngram", "min_gram": 1, "max_gram": 20},
+					"body_infix":  map[string]interface{}{"type": "ngram", "min_gram": 2, "max_gram": 3},
+				},
+				"analyzer": map[string]interface{}{
+					"body_prefix": map[string]interface{}{"type": "custom", "tokenizer": "standard", "filter": []string{"lowercase", "body_prefix"}},
+					"body_infix":  map[string]interface{}{"type": "custom", "tokenizer": "standard", "filter": []string{"lowercase", "body_infix"}},
+				},
+			},
+		},
+		"mappings": map[string]interface{}{
+			"dynamic":    "strict",
+			"properties": properties,
+		},
+	})
+	return string(body)
+}
+
+// EnsureIndex makes sure Alias points to an index with the current
+// mapping. On a fresh cluster it creates Index behind the alias. A legacy
+// index that is itself called "messages" keeps being served as it is; the
+// alias replaces it when a reindex swaps in a new index. An index that
+// only lacks the body subfields is kept and served too, see SubfieldCheck.
+func EnsureIndex(ctx context.Context, es *elasticsearch.Client) error {
+	indices, err := AliasIndices(ctx, es)
+	if err != nil {
+		return err
+	}
+
+	if len(indices) == 0 {
+		legacy, err := IndexExists(ctx, es, Alias)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			log.Printf("Serving legacy index %q until a reindex (cmd/reindex or POST /admin/search/reindex) replaces it with %q", Alias, Index)
+			return nil
+		}
+
+		if indices, err = createAlias(ctx, es); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range indices {
+		err := VerifyMapping(ctx, es, index)
+		if errors.Is(err, ErrOutdatedMapping) {
+			log.Printf("%v; prefix and substring search only match whole words until the messages are reindexed (cmd/reindex or POST /admin/search/reindex)", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createAlias(ctx context.Context, es *elasticsearch.Client) ([]string, error) {
+	if err := CreateIndex(ctx, es, Index); err != nil {
+		return nil, err
+	}
+
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": Index, "alias": Alias}},
+	}
+	if err := UpdateAliases(ctx, es, actions); err != nil {
+		// Another instance may have got there first
+		if indices, checkErr := AliasIndices(ctx, es); checkErr == nil && len(indices) > 0 {
+			return indices, nil
+		}
+		return nil, err
+	}
+	return []string{Index}, nil
+}
+
+// CreateIndex creates a physical index with the current mapping. An index
+// that already exists is left alone.
+func CreateIndex(ctx context.Context, es *elasticsearch.Client, name string) error {
+	res, err := es.Indices.Create(name,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(strings.NewReader(IndexBody())),
+	)
+	if err != nil {
+		return fmt.Errorf("creating index %s: %w", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+		return fmt.Errorf("creating index %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// AliasIndices returns the physical indices behind Alias.
+func AliasIndices(ctx context.Context, es *elasticsearch.Client) ([]string, error) {
+	res, err := es.Indices.GetAlias(
+		es.Indices.GetAlias.WithContext(ctx),
+		es.Indices.GetAlias.WithName(Alias),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("looking up alias %s: %w", Alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("looking up alias %s: %s", Alias, res.String())
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding alias %s: %w", Alias, err)
+	}
+
+	indices := make([]string, 0, len(result))
+	for index := range result {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// UpdateAliases applies alias actions atomically.
+func UpdateAliases(ctx context.Context, es *elasticsearch.Client, actions []map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.UpdateAliases(strings.NewReader(string(body)),
+		es.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("updating aliases: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("updating aliases: %s", res.String())
+	}
+	return nil
+}
+
+// VerifyMapping checks that every document field is mapped in index with
+// the expected type. An index created before the body subfields existed
+// yields ErrOutdatedMapping.
+func VerifyMapping(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.GetMapping(
+		es.Indices.GetMapping.WithContext(ctx),
+		es.Indices.GetMapping.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("reading mapping of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("reading mapping of %s: %s", index, res.String())
+	}
+
+	var result map[string]struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type   string `json:"type"`
+				Fields map[string]struct {
+					Type string `json:"type"`
+				} `json:"fields"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding mapping of %s: %w", index, err)
+	}
+
+	properties := result[index].Mappings.Properties
+	var problems []string
+	for field, want := range fieldTypes {
+		got, ok := properties[field]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not mapped", field))
+		case got.Type != want:
+			problems = append(problems, fmt.Sprintf("%s is %s instead of %s", field, got.Type, want))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("index %s does not match the message mapping: %s", index, strings.Join(problems, ", "))
+	}
+
+	for _, field := range []string{BodyPrefix, BodyInfix} {
+		if _, ok := properties["body"].Fields[strings.TrimPrefix(field, "body.")]; !ok {
+			return fmt.Errorf("%w: %s has no %s", ErrOutdatedMapping, index, field)
+		}
+	}
+	return nil
+}
+
+// UpdateSettings changes dynamic settings of index, such as
+// "index.refresh_interval". A nil value restores the default.
+func UpdateSettings(ctx context.Context, es *elasticsearch.Client, index string, settings map[string]interface{}) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.PutSettings(strings.NewReader(string(body)),
+		es.Indices.PutSettings.WithContext(ctx),
+		es.Indices.PutSettings.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("updating settings of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("updating settings of %s: %s", index, res.String())
+	}
+	return nil
+}
+
+// Refresh makes everything written to index visible to searches.
+func Refresh(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Refresh(
+		es.Indices.Refresh.WithContext(ctx),
+		es.Indices.Refresh.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("refreshing %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("refreshing %s: %s", index, res.String())
+	}
+	return nil
+}
+
+// DeleteIndex removes a physical index. One that is already gone is not an
+// error.
+func DeleteIndex(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Delete([]string{index}, es.Indices.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("deleting index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("deleting index %s: %s", index, res.String())
+	}
+	return nil
+}
+
+// IndexExists reports whether a physical index or alias called name exists.
+func IndexExists(ctx context.Context, es *elasticsearch.Client, name string) (bool, error) {
+	res, err := es.Indices.Exists([]string{name}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("checking for index %s: %w", name, err)
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK, nil
+}
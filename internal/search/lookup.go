@@ -0,0 +1,196 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// CountByChat returns how many documents each of the given chats has in
+// index, counting only documents last updated before the given time.
+// Chats without documents are left out.
+func CountByChat(ctx context.Context, es *elasticsearch.Client, index string, chatIDs []uint, before time.Time) (map[uint]int64, error) {
+	counts := make(map[uint]int64, len(chatIDs))
+	if len(chatIDs) == 0 {
+		return counts, nil
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"terms": map[string]interface{}{"chat_id": chatIDs}},
+					map[string]interface{}{"range": map[string]interface{}{"updated_at": map[string]interface{}{"lt": before}}},
+				},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"chats": map[string]interface{}{"terms": map[string]interface{}{"field": "chat_id", "size": len(chatIDs)}},
+		},
+	}
+
+	var result struct {
+		Aggregations struct {
+			Chats struct {
+				Buckets []struct {
+					Key      uint  `json:"key"`
+					DocCount int64 `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"chats"`
+		} `json:"aggregations"`
+	}
+	if err := searchIndex(ctx, es, index, query, &result); err != nil {
+		return nil, fmt.Errorf("counting chat documents in %s: %w", index, err)
+	}
+
+	for _, bucket := range result.Aggregations.Chats.Buckets {
+		counts[bucket.Key] = bucket.DocCount
+	}
+	return counts, nil
+}
+
+// ChatDocuments returns the documents of a chat whose message numbers are
+// greater than after and at most upTo, in message number order. They are
+// read pageSize at a time.
+func ChatDocuments(ctx context.Context, es *elasticsearch.Client, index string, chatID uint, after, upTo, pageSize int) ([]Document, error) {
+	var documents []Document
+	for {
+		query := map[string]interface{}{
+			"size": pageSize,
+			"query": map[string]interface{}{
+				"bool": map[string]interface{}{
+					"filter": []interface{}{
+						map[string]interface{}{"term": map[string]interface{}{"chat_id": chatID}},
+						map[string]interface{}{"range": map[string]interface{}{"message_number": map[string]interface{}{"gt": after, "lte": upTo}}},
+					},
+				},
+			},
+			"sort": []interface{}{map[string]interface{}{"message_number": "asc"}},
+		}
+
+		var result hitsResult
+		if err := searchIndex(ctx, es, index, query, &result); err != nil {
+			return nil, fmt.Errorf("reading documents of chat %d from %s: %w", chatID, index, err)
+		}
+
+		for _, hit := range result.Hits.Hits {
+			documents = append(documents, hit.Source)
+		}
+		if len(result.Hits.Hits) < pageSize {
+			return documents, nil
+		}
+		after = documents[len(documents)-1].MessageNumber
+	}
+}
+
+// GetDocuments looks up documents by ID and returns the ones that exist,
+// keyed by ID.
+func GetDocuments(ctx context.Context, es *elasticsearch.Client, index string, ids []string) (map[string]Document, error) {
+	documents := make(map[string]Document, len(ids))
+	if len(ids) == 0 {
+		return documents, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := es.Mget(bytes.NewReader(body),
+		es.Mget.WithContext(ctx),
+		es.Mget.WithIndex(index),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("getting documents from %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("getting documents from %s: %s", index, res.String())
+	}
+
+	var result struct {
+		Docs []struct {
+			ID     string   `json:"_id"`
+			Found  bool     `json:"found"`
+			Source Document `json:"_source"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding documents from %s: %w", index, err)
+	}
+
+	for _, doc := range result.Docs {
+		if doc.Found {
+			documents[doc.ID] = doc.Source
+		}
+	}
+	return documents, nil
+}
+
+// SampleDocuments returns up to n documents picked at random from those
+// last updated before the given time.
+func SampleDocuments(ctx context.Context, es *elasticsearch.Client, index string, n int, before time.Time) ([]Document, error) {
+	query := map[string]interface{}{
+		"size": n,
+		"query": map[string]interface{}{
+			"function_score": map[string]interface{}{
+				"query": map[string]interface{}{
+					"range": map[string]interface{}{"updated_at": map[string]interface{}{"lt": before}},
+				},
+				"random_score": map[string]interface{}{},
+				"boost_mode":   "replace",
+			},
+		},
+	}
+
+	var result hitsResult
+	if err := searchIndex(ctx, es, index, query, &result); err != nil {
+		return nil, fmt.Errorf("sampling documents from %s: %w", index, err)
+	}
+
+	documents := make([]Document, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		documents[i] = hit.Source
+	}
+	return documents, nil
+}
+
+type hitsResult struct {
+	Hits struct {
+		Hits []struct {
+			Source Document `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// searchIndex runs query against index and decodes the response into
+// result.
+func searchIndex(ctx context.Context, es *elasticsearch.Client, index string, query, result interface{}) error {
+	body, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(index),
+		es.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return errors.New(res.String())
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// subfieldRecheckInterval is how often an outdated alias is checked again,
+// so searches pick up a finished reindex without a restart.
+const subfieldRecheckInterval = time.Minute
+
+// SubfieldCheck tracks whether the index behind Alias has the body
+// subfields that prefix and substring search rely on. An index created
+// before them, or a legacy index named like the alias, is still served;
+// searches fall back to matching whole words in the body until a reindex
+// moves the alias to an index that has them.
+type SubfieldCheck struct {
+	es *elasticsearch.Client
+
+	mu        sync.Mutex
+	available bool
+	checkedAt time.Time
+}
+
+func NewSubfieldCheck(es *elasticsearch.Client) *SubfieldCheck {
+	return &SubfieldCheck{es: es}
+}
+
+// Available reports whether BodyPrefix and BodyInfix can be searched. Once
+// they can, they stay available, since the alias only ever moves to indices
+// with the current mapping. If the mapping cannot be read the subfields
+// are assumed to be there and the check is repeated on the next search.
+func (c *SubfieldCheck) Available(ctx context.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.available || time.Since(c.checkedAt) < subfieldRecheckInterval {
+		return c.available
+	}
+
+	indices, err := AliasIndices(ctx, c.es)
+	if err != nil {
+		log.Printf("Error checking the message index mapping: %v", err)
+		return true
+	}
+
+	// Without the alias, a legacy index called like it is being served
+	available := len(indices) > 0
+	for _, index := range indices {
+		err := VerifyMapping(ctx, c.es, index)
+		if errors.Is(err, ErrOutdatedMapping) {
+			available = false
+			continue
+		}
+		if err != nil {
+			log.Printf("Error checking the message index mapping: %v", err)
+			return true
+		}
+	}
+
+	c.available, c.checkedAt = available, time.Now()
+	return available
+}
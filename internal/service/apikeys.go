@@ -0,0 +1,166 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes an admin API key can be granted.
+const (
+	ScopeApplicationsRead  = "applications:read"
+	ScopeApplicationsWrite = "applications:write"
+	ScopeAdmin             = "admin"
+)
+
+var Scopes = []string{ScopeApplicationsRead, ScopeApplicationsWrite, ScopeAdmin}
+
+// ScopeBootstrap is held by the bootstrap key alone and only allows
+// creating API keys. It cannot be granted to keys.
+const ScopeBootstrap = "bootstrap"
+
+const (
+	apiKeyPrefix = "csk_"
+
+	// lastUsedInterval limits how often using a key writes last_used_at
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidScope   = errors.New("unknown scope")
+)
+
+// APIKeyService issues and checks the admin API keys that guard the
+// management endpoints. Keys are stored as SHA-256 hashes, which is enough
+// for random keys of this length and keeps lookups a single index hit.
+type APIKeyService struct {
+	db *gorm.DB
+
+	// bootstrapKeyHash is the hash of the key from ADMIN_API_KEY. It grants
+	// ScopeBootstrap until a key with the admin scope exists, so the first
+	// keys can be created.
+	bootstrapKeyHash string
+}
+
+func NewAPIKeyService(db *gorm.DB, bootstrapKey string) *APIKeyService {
+	return &APIKeyService{db: db, bootstrapKeyHash: hashAPIKey(bootstrapKey)}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateKey issues a new key. The plain key is returned only here; it
+// cannot be recovered later.
+func (s *APIKeyService) CreateKey(ctx context.Context, name string, scopes []string) (*models.APIKey, string, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+
+	apiKey := models.APIKey{
+		Name:    name,
+		Prefix:  key[:len(apiKeyPrefix)+8],
+		KeyHash: hashAPIKey(key),
+		Scopes:  strings.Join(slices.Compact(slices.Sorted(slices.Values(scopes))), ","),
+	}
+	if err := s.db.WithContext(ctx).Create(&apiKey).Error; err != nil {
+		return nil, "", err
+	}
+	return &apiKey, key, nil
+}
+
+// ListKeys returns all keys, revoked ones included, newest first.
+func (s *APIKeyService) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.WithContext(ctx).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeKey disables a key immediately. Revoking a revoked key is a no-op.
+func (s *APIKeyService) RevokeKey(ctx context.Context, id uint) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	if err := s.db.WithContext(ctx).First(&apiKey, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	if apiKey.RevokedAt == nil {
+		now := time.Now().UTC()
+		apiKey.RevokedAt = &now
+		if err := s.db.WithContext(ctx).Model(&apiKey).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &apiKey, nil
+}
+
+// Authenticate returns the scopes a key grants, or nil if the key is
+// unknown or revoked.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) ([]string, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	hash := hashAPIKey(key)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(s.bootstrapKeyHash)) == 1 {
+		return s.bootstrapScopes(ctx)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil
+	}
+
+	var apiKey models.APIKey
+	result := s.db.WithContext(ctx).
+		Where("key_hash = ? AND revoked_at IS NULL", hash).
+		Limit(1).Find(&apiKey)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	// Best effort; a missed update only makes last_used_at a little stale
+	now := time.Now().UTC()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > lastUsedInterval {
+		s.db.WithContext(ctx).Model(&apiKey).Update("last_used_at", now)
+	}
+	return apiKey.ScopeList(), nil
+}
+
+// bootstrapScopes returns what the bootstrap key grants: nothing once an
+// active key holds the admin scope, since from then on keys are managed
+// with that.
+func (s *APIKeyService) bootstrapScopes(ctx context.Context) ([]string, error) {
+	var admins int64
+	if err := s.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("revoked_at IS NULL AND FIND_IN_SET(?, scopes) > 0", ScopeAdmin).
+		Count(&admins).Error; err != nil {
+		return nil, err
+	}
+	if admins > 0 {
+		return nil, nil
+	}
+	return []string{ScopeBootstrap}, nil
+}
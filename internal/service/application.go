@@ -5,16 +5,39 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
 
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxTokenGracePeriod caps how long a rotated-out token keeps working.
+const MaxTokenGracePeriod = 30 * 24 * time.Hour
+
+// planCacheTTL bounds how long a plan change takes to reach the rate
+// limiter on other replicas; SetPlan drops the cache right away.
+const planCacheTTL = 5 * time.Minute
+
+var (
+	ErrInvalidGracePeriod = errors.New("grace period must be between 0 and 30 days")
+	ErrUnknownPlan        = errors.New("unknown plan")
 )
 
 type ApplicationService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	redis  *redis.Client
+	tokens *TokenResolver
+
+	// plans are the plan names an application can be put on
+	plans []string
 }
 
-func NewApplicationService(db *gorm.DB) *ApplicationService {
-	return &ApplicationService{db: db}
+func NewApplicationService(db *gorm.DB, redis *redis.Client, tokens *TokenResolver, plans []string) *ApplicationService {
+	return &ApplicationService{db: db, redis: redis, tokens: tokens, plans: plans}
 }
 
 func generateToken() (string, error) {
@@ -43,23 +66,55 @@ func (s *ApplicationService) CreateApplication(ctx context.Context, name string)
 	return &app, nil
 }
 
+// GetApplicationByToken looks an application up by its current token or one
+// still in its grace period.
 func (s *ApplicationService) GetApplicationByToken(ctx context.Context, token string) (*models.Application, error) {
+	appID, err := s.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
 	var app models.Application
-	if err := s.db.Where("token = ?", token).First(&app).Error; err != nil {
+	err = s.db.WithContext(ctx).First(&app, appID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrApplicationNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return &app, nil
 }
 
-func (s *ApplicationService) GetAllApplications(ctx context.Context, page int, limit int) ([]models.Application, error) {
+// TokenByID returns the current token of an application, for the admin
+// routes that name an application by the ID shown in the application list.
+func (s *ApplicationService) TokenByID(ctx context.Context, id uint) (string, error) {
+	var app models.Application
+	err := s.db.WithContext(ctx).Select("id, token").First(&app, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrApplicationNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return app.Token, nil
+}
+
+// GetAllApplications returns one page of applications in creation order
+// and the total number of applications.
+func (s *ApplicationService) GetAllApplications(ctx context.Context, page int, limit int) ([]models.Application, int64, error) {
 	var apps []models.Application
+	var total int64
+
+	if err := s.db.WithContext(ctx).Model(&models.Application{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 
 	// Apply pagination
 	offset := (page - 1) * limit
-	if err := s.db.Offset(offset).Limit(limit).Find(&apps).Error; err != nil {
-		return nil, err
+	if err := s.db.WithContext(ctx).Order("id").Offset(offset).Limit(limit).Find(&apps).Error; err != nil {
+		return nil, 0, err
 	}
-	return apps, nil
+	return apps, total, nil
 }
 
 func (s *ApplicationService) UpdateApplication(ctx context.Context, token string, name string) (*models.Application, error) {
@@ -69,29 +124,196 @@ func (s *ApplicationService) UpdateApplication(ctx context.Context, token string
 		return nil, err
 	}
 
+	// Only the name is written so a concurrent token rotation is not undone
 	app.Name = name
-	if err := s.db.Save(app).Error; err != nil {
+	if err := s.db.WithContext(ctx).Model(app).Update("name", name).Error; err != nil {
 		return nil, err
 	}
 
 	return app, nil
 }
 
-func (s *ApplicationService) GetChatsWithApplicationByToken(ctx context.Context, token string, page int, limit int) ([]models.Chat, error) {
+// GetChatsWithApplicationByToken returns one page of an application's chats
+// ordered by chat number, and the total number of chats.
+func (s *ApplicationService) GetChatsWithApplicationByToken(ctx context.Context, token string, page int, limit int) ([]models.Chat, int64, error) {
+	return s.listChats(ctx, token, func(q *gorm.DB) *gorm.DB {
+		return q.Offset((page - 1) * limit).Limit(limit)
+	})
+}
+
+// GetChatsAfter returns up to limit chats of an application numbered after
+// afterNumber, for cursor pagination, and the total number of chats.
+func (s *ApplicationService) GetChatsAfter(ctx context.Context, token string, afterNumber int, limit int) ([]models.Chat, int64, error) {
+	return s.listChats(ctx, token, func(q *gorm.DB) *gorm.DB {
+		return q.Where("chats.chat_number > ?", afterNumber).Limit(limit)
+	})
+}
+
+func (s *ApplicationService) listChats(ctx context.Context, token string, paginate func(*gorm.DB) *gorm.DB) ([]models.Chat, int64, error) {
 	var chats []models.Chat
+	var total int64
+
+	appID, err := s.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	// Perform a single query with a join to fetch chats and the application ID
-	offset := (page - 1) * limit
-	err := s.db.Table("applications").
-		Select("chats.chat_number, chats.messages_count").
-		Joins("JOIN chats ON chats.application_id = applications.id").
-		Where("applications.token = ?", token).
-		Offset(offset).Limit(limit).
+	base := func() *gorm.DB {
+		return s.db.WithContext(ctx).Table("applications").
+			Joins("JOIN chats ON chats.application_id = applications.id").
+			Where("applications.id = ?", appID).
+			Where("applications.deleted_at IS NULL AND chats.deleted_at IS NULL")
+	}
+
+	if err := base().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err = paginate(base().Select("chats.chat_number, chats.messages_count")).
+		Order("chats.chat_number").
 		Scan(&chats).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return chats, total, nil
+}
+
+// RotateToken gives an application a new token. The old one keeps working
+// for gracePeriod so clients can switch over; a zero grace period retires
+// it at once. Chats, messages and counters are keyed by the application ID
+// and are unaffected.
+func (s *ApplicationService) RotateToken(ctx context.Context, token string, gracePeriod time.Duration) (*models.Application, *time.Time, error) {
+	if gracePeriod < 0 || gracePeriod > MaxTokenGracePeriod {
+		return nil, nil, ErrInvalidGracePeriod
+	}
+
+	var expiresAt *time.Time
+	app, err := s.replaceToken(ctx, token, func(tx *gorm.DB, app *models.Application) ([]string, error) {
+		// The old token's cache entry is dropped either way, so a grace period
+		// shorter than the cache TTL is still honoured
+		retired := []string{app.Token}
+		if gracePeriod == 0 {
+			return retired, nil
+		}
 
+		expires := time.Now().UTC().Add(gracePeriod)
+		expiresAt = &expires
+		return retired, tx.Create(&models.ApplicationToken{
+			ApplicationID: app.ID,
+			Token:         app.Token,
+			ExpiresAt:     expires,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, expiresAt, nil
+}
+
+// RevokeTokens gives an application a new token and invalidates the current
+// one and every token still in its grace period, effective immediately.
+func (s *ApplicationService) RevokeTokens(ctx context.Context, token string) (*models.Application, error) {
+	return s.replaceToken(ctx, token, func(tx *gorm.DB, app *models.Application) ([]string, error) {
+		var previous []string
+		if err := tx.Model(&models.ApplicationToken{}).
+			Where("application_id = ? AND revoked_at IS NULL", app.ID).
+			Pluck("token", &previous).Error; err != nil {
+			return nil, err
+		}
+
+		if err := tx.Model(&models.ApplicationToken{}).
+			Where("application_id = ? AND revoked_at IS NULL", app.ID).
+			Update("revoked_at", time.Now().UTC()).Error; err != nil {
+			return nil, err
+		}
+		return append(previous, app.Token), nil
+	})
+}
+
+// replaceToken swaps in a freshly generated token under a row lock, letting
+// retire decide what happens to the old one. Tokens retire returns stop
+// resolving once the transaction commits.
+func (s *ApplicationService) replaceToken(ctx context.Context, token string, retire func(tx *gorm.DB, app *models.Application) ([]string, error)) (*models.Application, error) {
+	appID, err := s.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	newToken, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var app models.Application
+	var retired []string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&app, appID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrApplicationNotFound
+			}
+			return err
+		}
+
+		var err error
+		if retired, err = retire(tx, &app); err != nil {
+			return err
+		}
+
+		app.Token = newToken
+		return tx.Model(&app).Update("token", newToken).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokens.Forget(ctx, retired...); err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+func planCacheKey(appID uint) string {
+	return fmt.Sprintf("app_plan:%d", appID)
+}
+
+// Plan returns the plan of an application by ID.
+func (s *ApplicationService) Plan(ctx context.Context, appID uint) (string, error) {
+	if plan, err := s.redis.Get(ctx, planCacheKey(appID)).Result(); err == nil {
+		return plan, nil
+	}
+
+	var app models.Application
+	if err := s.db.WithContext(ctx).Select("id, plan").First(&app, appID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrApplicationNotFound
+		}
+		return "", err
+	}
+
+	s.redis.Set(ctx, planCacheKey(appID), app.Plan, planCacheTTL)
+	return app.Plan, nil
+}
+
+// SetPlan moves an application to another plan, changing its rate limits
+// and daily quota.
+func (s *ApplicationService) SetPlan(ctx context.Context, token, plan string) (*models.Application, error) {
+	if !slices.Contains(s.plans, plan) {
+		return nil, ErrUnknownPlan
+	}
+
+	app, err := s.GetApplicationByToken(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 
-	return chats, nil
+	app.Plan = plan
+	if err := s.db.WithContext(ctx).Model(app).Update("plan", plan).Error; err != nil {
+		return nil, err
+	}
+	if err := s.redis.Del(ctx, planCacheKey(app.ID)).Err(); err != nil {
+		return nil, err
+	}
+	return app, nil
 }
@@ -4,7 +4,7 @@ import (
 	"chat-system/internal/db/models"
 	"chat-system/internal/queue"
 	"context"
-	"fmt"
+	"errors"
 	"sync"
 
 	"github.com/go-redis/redis/v8"
@@ -12,14 +12,17 @@ import (
 )
 
 type ChatService struct {
-	db    *gorm.DB
-	redis *redis.Client
-	queue *queue.MessageQueue
-	mu    sync.Mutex
+	db       *gorm.DB
+	redis    *redis.Client
+	queue    *queue.MessageQueue
+	counters *CounterService
+	tokens   *TokenResolver
+	resolver *ChatResolver
+	mu       sync.Mutex
 }
 
-func NewChatService(db *gorm.DB, redis *redis.Client, queue *queue.MessageQueue) *ChatService {
-	return &ChatService{db: db, redis: redis, queue: queue}
+func NewChatService(db *gorm.DB, redis *redis.Client, queue *queue.MessageQueue, counters *CounterService, tokens *TokenResolver, resolver *ChatResolver) *ChatService {
+	return &ChatService{db: db, redis: redis, queue: queue, counters: counters, tokens: tokens, resolver: resolver}
 }
 
 func (s *ChatService) CreateChat(ctx context.Context, appToken string) (*models.Chat, error) {
@@ -39,16 +42,23 @@ func (s *ChatService) CreateChat(ctx context.Context, appToken string) (*models.
 	}()
 
 	// Get application
+	appID, err := s.tokens.Resolve(ctx, appToken)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	var app models.Application
-	if err := tx.Where("token = ?", appToken).First(&app).Error; err != nil {
+	if err := tx.First(&app, appID).Error; err != nil {
 		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApplicationNotFound
+		}
 		return nil, err
 	}
 
 	// Get next chat number using Redis
-	chatNumKey := fmt.Sprintf("app:%d:next_chat_num", app.ID)
-
-	chatNum, err := s.redis.Incr(ctx, chatNumKey).Result()
+	chatNum, err := s.counters.NextChatNumber(ctx, app.ID)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
@@ -56,7 +66,7 @@ func (s *ChatService) CreateChat(ctx context.Context, appToken string) (*models.
 
 	chat := &models.Chat{
 		ApplicationID: app.ID,
-		ChatNumber:    int(chatNum),
+		ChatNumber:    chatNum,
 	}
 
 	// Queue the chat creation
@@ -65,10 +75,10 @@ func (s *ChatService) CreateChat(ctx context.Context, appToken string) (*models.
 		ChatNumber int  `json:"chat_number"`
 	}{
 		AppID:      app.ID,
-		ChatNumber: int(chatNum),
+		ChatNumber: chatNum,
 	}
 
-	if err := s.queue.Enqueue(ctx, "chat_creation", payload); err != nil {
+	if err := s.queue.Enqueue(ctx, "chat_creation", app.ID, payload); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
@@ -79,3 +89,17 @@ func (s *ChatService) CreateChat(ctx context.Context, appToken string) (*models.
 
 	return chat, nil
 }
+
+// GetChat returns a single chat of an application by its number.
+func (s *ChatService) GetChat(ctx context.Context, appToken string, chatNumber int) (*models.Chat, error) {
+	resolved, err := s.resolver.Resolve(ctx, appToken, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var chat models.Chat
+	if err := s.db.WithContext(ctx).First(&chat, resolved.ChatID).Error; err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// CounterService hands out chat and message numbers from Redis counters and
+// keeps those counters in line with what is stored in MySQL, so a flushed
+// or restarted Redis never makes numbering start over.
+type CounterService struct {
+	db    *gorm.DB
+	redis *redis.Client
+}
+
+// ReconcileResult reports how many counters a reconciliation raised.
+type ReconcileResult struct {
+	ChatCounters    int `json:"chat_counters"`
+	MessageCounters int `json:"message_counters"`
+}
+
+// raiseScript sets a counter to ARGV[1] unless it already holds a higher
+// value, so numbers that were handed out but are still queued are kept.
+var raiseScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local stored = tonumber(ARGV[1])
+if stored > current then
+	redis.call('SET', KEYS[1], stored)
+	return 1
+end
+return 0
+`)
+
+func NewCounterService(db *gorm.DB, redis *redis.Client) *CounterService {
+	return &CounterService{db: db, redis: redis}
+}
+
+func chatCounterKey(appID uint) string {
+	return fmt.Sprintf("app:%d:next_chat_num", appID)
+}
+
+func messageCounterKey(chatID uint) string {
+	return fmt.Sprintf("chat:%d:next_msg_num", chatID)
+}
+
+// NextChatNumber returns the next chat number for an application.
+func (s *CounterService) NextChatNumber(ctx context.Context, appID uint) (int, error) {
+	return s.next(ctx, chatCounterKey(appID),
+		"SELECT COALESCE(MAX(chat_number), 0) FROM chats WHERE application_id = ?", appID)
+}
+
+// NextMessageNumber returns the next message number for a chat.
+func (s *CounterService) NextMessageNumber(ctx context.Context, chatID uint) (int, error) {
+	return s.next(ctx, messageCounterKey(chatID),
+		"SELECT COALESCE(MAX(message_number), 0) FROM messages WHERE chat_id = ?", chatID)
+}
+
+// next increments a counter, first seeding it from MySQL if the key is
+// missing. SETNX makes concurrent seeding safe: only the first write sticks
+// and every caller then increments past it.
+func (s *CounterService) next(ctx context.Context, key string, maxQuery string, id uint) (int, error) {
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if exists == 0 {
+		var stored int
+		if err := s.db.WithContext(ctx).Raw(maxQuery, id).Scan(&stored).Error; err != nil {
+			return 0, err
+		}
+		if err := s.redis.SetNX(ctx, key, stored, 0).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// ForgetChat removes the message counter of a deleted chat.
+func (s *CounterService) ForgetChat(ctx context.Context, chatID uint) error {
+	return s.redis.Del(ctx, messageCounterKey(chatID)).Err()
+}
+
+// ForgetApplication removes the chat counter of a deleted application.
+func (s *CounterService) ForgetApplication(ctx context.Context, appID uint) error {
+	return s.redis.Del(ctx, chatCounterKey(appID)).Err()
+}
+
+// Reconcile raises every counter to at least the highest number stored in
+// MySQL. It never lowers a counter and is safe to run from several
+// instances at once.
+func (s *CounterService) Reconcile(ctx context.Context) (*ReconcileResult, error) {
+	var result ReconcileResult
+	var err error
+
+	result.ChatCounters, err = s.reconcile(ctx,
+		"SELECT application_id, MAX(chat_number) FROM chats GROUP BY application_id", chatCounterKey)
+	if err != nil {
+		return &result, fmt.Errorf("reconciling chat counters: %w", err)
+	}
+
+	result.MessageCounters, err = s.reconcile(ctx,
+		"SELECT chat_id, MAX(message_number) FROM messages GROUP BY chat_id", messageCounterKey)
+	if err != nil {
+		return &result, fmt.Errorf("reconciling message counters: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (s *CounterService) reconcile(ctx context.Context, query string, key func(uint) string) (int, error) {
+	rows, err := s.db.WithContext(ctx).Raw(query).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	raised := 0
+	for rows.Next() {
+		var id uint
+		var max int
+		if err := rows.Scan(&id, &max); err != nil {
+			return raised, err
+		}
+
+		n, err := raiseScript.Run(ctx, s.redis, []string{key(id)}, max).Int()
+		if err != nil {
+			return raised, err
+		}
+		raised += n
+	}
+
+	return raised, rows.Err()
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// DeletionService deletes applications and chats. The resource disappears
+// from the API right away; its rows, counters and search documents are
+// removed by a background job whose progress can be followed through the
+// job store.
+type DeletionService struct {
+	db       *gorm.DB
+	queue    *queue.MessageQueue
+	jobs     *jobs.Store
+	tokens   *TokenResolver
+	resolver *ChatResolver
+}
+
+func NewDeletionService(db *gorm.DB, queue *queue.MessageQueue, jobs *jobs.Store, tokens *TokenResolver, resolver *ChatResolver) *DeletionService {
+	return &DeletionService{db: db, queue: queue, jobs: jobs, tokens: tokens, resolver: resolver}
+}
+
+// DeleteApplication hides an application and queues the removal of all its
+// chats and messages.
+func (s *DeletionService) DeleteApplication(ctx context.Context, token string) (*jobs.Job, error) {
+	appID, err := s.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var app models.Application
+	err = s.db.WithContext(ctx).Take(&app, appID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrApplicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens are credentials, and jobs can be read by anyone allowed to
+	// follow them, so the job names the application by its ID
+	job, err := s.jobs.Create(ctx, "application_deletion", strconv.FormatUint(uint64(app.ID), 10), app.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := struct {
+		JobID         string `json:"job_id"`
+		ApplicationID uint   `json:"application_id"`
+	}{
+		JobID:         job.ID,
+		ApplicationID: app.ID,
+	}
+
+	// Queued on the application's shard, so chats still waiting to be
+	// created are written before they are deleted
+	if err := s.queue.Enqueue(ctx, "application_deletion", app.ID, payload); err != nil {
+		return nil, err
+	}
+
+	// The worker hides the application too; doing it here means the API
+	// stops serving it before the job is picked up
+	if err := s.db.WithContext(ctx).Delete(&app).Error; err != nil {
+		return nil, fmt.Errorf("hiding application: %w", err)
+	}
+	s.resolver.ForgetApplication(ctx, app.ID)
+	s.forgetTokens(ctx, app)
+
+	return job, nil
+}
+
+// DeleteChat hides a chat and queues the removal of its messages.
+func (s *DeletionService) DeleteChat(ctx context.Context, token string, chatNumber int) (*jobs.Job, error) {
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := s.jobs.Create(ctx, "chat_deletion", fmt.Sprintf("%d/%d", chat.ApplicationID, chatNumber), chat.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := struct {
+		JobID  string `json:"job_id"`
+		ChatID uint   `json:"chat_id"`
+	}{
+		JobID:  job.ID,
+		ChatID: chat.ChatID,
+	}
+
+	// Queued on the chat's shard, behind any of its messages still waiting
+	if err := s.queue.Enqueue(ctx, "chat_deletion", chat.ChatID, payload); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Delete(&models.Chat{}, chat.ChatID).Error; err != nil {
+		return nil, fmt.Errorf("hiding chat: %w", err)
+	}
+	s.resolver.Forget(ctx, chat.ApplicationID, chatNumber)
+
+	return job, nil
+}
+
+// forgetTokens drops the cached lookups of every token of a deleted
+// application, including ones still in their grace period.
+func (s *DeletionService) forgetTokens(ctx context.Context, app models.Application) {
+	var previous []string
+	s.db.WithContext(ctx).Model(&models.ApplicationToken{}).
+		Where("application_id = ?", app.ID).
+		Pluck("token", &previous)
+	s.tokens.Forget(ctx, append(previous, app.Token)...)
+}
+
+// GetJob returns the progress of a background job.
+func (s *DeletionService) GetJob(ctx context.Context, id string) (*jobs.Job, error) {
+	return s.jobs.Get(ctx, id)
+}
+
+// GetApplicationJob returns the progress of a job of the application token
+// belongs to. Jobs of other applications are reported as not found.
+func (s *DeletionService) GetApplicationJob(ctx context.Context, token, id string) (*jobs.Job, error) {
+	appID, err := s.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.ApplicationID != appID {
+		return nil, jobs.ErrJobNotFound
+	}
+	return job, nil
+}
@@ -2,44 +2,66 @@ package service
 
 import (
 	"chat-system/internal/db/models"
+	"chat-system/internal/pkg/httputil"
 	"chat-system/internal/queue"
+	"chat-system/internal/search"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessagePending is returned for a message whose number was handed
+	// out but which the worker has not written yet.
+	ErrMessagePending = errors.New("message is still being created")
+)
+
 type MessageService struct {
-	db    *gorm.DB
-	redis *redis.Client
-	queue *queue.MessageQueue
-	es    *elasticsearch.Client
-	mu    sync.Mutex
+	db       *gorm.DB
+	redis    *redis.Client
+	queue    *queue.MessageQueue
+	es       *elasticsearch.Client
+	resolver *ChatResolver
+	counters *CounterService
+	mu       sync.Mutex
+
+	// subfields tells whether the index can serve prefix and substring search
+	subfields *search.SubfieldCheck
 }
 
-func NewMessageService(db *gorm.DB, redis *redis.Client, queue *queue.MessageQueue, es *elasticsearch.Client) *MessageService {
-	return &MessageService{db: db, redis: redis, queue: queue, es: es}
+func NewMessageService(db *gorm.DB, redis *redis.Client, queue *queue.MessageQueue, es *elasticsearch.Client, resolver *ChatResolver, counters *CounterService) *MessageService {
+	return &MessageService{db: db, redis: redis, queue: queue, es: es, resolver: resolver, counters: counters, subfields: search.NewSubfieldCheck(es)}
 }
 
-func (s *MessageService) CreateMessage(ctx context.Context, chatID uint, body string) (*models.Message, error) {
+func (s *MessageService) CreateMessage(ctx context.Context, token string, chatNumber int, body string) (*models.Message, error) {
+	// Resolve the chat first so unknown chats never get a message number
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+	chatID := chat.ChatID
+
 	s.mu.Lock()         // Locking the mutex to prevent race conditions
 	defer s.mu.Unlock() // Ensure the mutex is unlocked at the end of the function
 
 	// Get next message number using Redis
-	msgNumKey := fmt.Sprintf("chat:%d:next_msg_num", chatID)
-	msgNum, err := s.redis.Incr(ctx, msgNumKey).Result()
+	msgNum, err := s.counters.NextMessageNumber(ctx, chatID)
 	if err != nil {
 		return nil, err
 	}
 
 	message := &models.Message{
 		ChatID:        chatID,
-		MessageNumber: int(msgNum),
+		MessageNumber: msgNum,
 		Body:          body,
 	}
 
@@ -50,57 +72,271 @@ func (s *MessageService) CreateMessage(ctx context.Context, chatID uint, body st
 		Body          string `json:"body"`
 	}{
 		ChatID:        chatID,
-		MessageNumber: int(msgNum),
+		MessageNumber: msgNum,
 		Body:          body,
 	}
 
-	if err := s.queue.Enqueue(ctx, "message_creation", payload); err != nil {
+	if err := s.queue.Enqueue(ctx, "message_creation", chatID, payload); err != nil {
 		return nil, err
 	}
 
 	return message, nil
 }
 
-func (s *MessageService) GetMessagesByChatNumberAndToken(ctx context.Context, token string, chatNumber uint) ([]models.Message, error) {
-	var messages []models.Message
+// GetMessage returns a single message of a chat by its number.
+func (s *MessageService) GetMessage(ctx context.Context, token string, chatNumber int, messageNumber int) (*models.Message, error) {
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var message models.Message
+	err = s.db.WithContext(ctx).
+		Where("chat_id = ? AND message_number = ?", chat.ChatID, messageNumber).
+		Take(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, s.missingMessageError(ctx, chat.ChatID, messageNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// missingMessageError tells apart a message that is still queued for
+// creation and one that does not exist or was deleted.
+func (s *MessageService) missingMessageError(ctx context.Context, chatID uint, messageNumber int) error {
+	var deleted int64
+	if err := s.db.WithContext(ctx).Unscoped().Model(&models.Message{}).
+		Where("chat_id = ? AND message_number = ?", chatID, messageNumber).
+		Count(&deleted).Error; err != nil {
+		return err
+	}
+	if deleted > 0 {
+		return ErrMessageNotFound
+	}
+
+	lastIssued, err := s.redis.Get(ctx, messageCounterKey(chatID)).Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if messageNumber > 0 && messageNumber <= lastIssued {
+		return ErrMessagePending
+	}
+	return ErrMessageNotFound
+}
+
+// UpdateMessage queues a new body for a message. Jobs for a chat are
+// processed in order, so a message that is itself still queued can be
+// edited too.
+func (s *MessageService) UpdateMessage(ctx context.Context, token string, chatNumber int, messageNumber int, body string) (*models.Message, error) {
+	chat, err := s.queueableMessage(ctx, token, chatNumber, messageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	editedAt := time.Now().UTC()
+	payload := struct {
+		ChatID        uint      `json:"chat_id"`
+		MessageNumber int       `json:"message_number"`
+		Body          string    `json:"body"`
+		EditedAt      time.Time `json:"edited_at"`
+	}{
+		ChatID:        chat.ChatID,
+		MessageNumber: messageNumber,
+		Body:          body,
+		EditedAt:      editedAt,
+	}
+
+	if err := s.queue.Enqueue(ctx, "message_update", chat.ChatID, payload); err != nil {
+		return nil, err
+	}
+
+	return &models.Message{
+		ChatID:        chat.ChatID,
+		MessageNumber: messageNumber,
+		Body:          body,
+		EditedAt:      &editedAt,
+	}, nil
+}
+
+// DeleteMessage queues the removal of a message from MySQL and the search
+// index.
+func (s *MessageService) DeleteMessage(ctx context.Context, token string, chatNumber int, messageNumber int) error {
+	chat, err := s.queueableMessage(ctx, token, chatNumber, messageNumber)
+	if err != nil {
+		return err
+	}
+
+	payload := struct {
+		ChatID        uint `json:"chat_id"`
+		MessageNumber int  `json:"message_number"`
+	}{
+		ChatID:        chat.ChatID,
+		MessageNumber: messageNumber,
+	}
+
+	return s.queue.Enqueue(ctx, "message_deletion", chat.ChatID, payload)
+}
+
+// queueableMessage resolves the chat of a message that exists or is still
+// waiting to be created.
+func (s *MessageService) queueableMessage(ctx context.Context, token string, chatNumber int, messageNumber int) (*ResolvedChat, error) {
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.GetMessage(ctx, token, chatNumber, messageNumber)
+	if err != nil && !errors.Is(err, ErrMessagePending) {
+		return nil, err
+	}
+
+	return chat, nil
+}
+
+// ListMessages returns one page of a chat's messages using keyset
+// pagination on the message number, so deep pages cost the same as the
+// first one.
+func (s *MessageService) ListMessages(ctx context.Context, token string, chatNumber int, q MessagePageQuery) (*MessagePage, error) {
+	// The cursor carries the bounds and order of the following page
+	type cursor struct {
+		After  int    `json:"a,omitempty"`
+		Before int    `json:"b,omitempty"`
+		Order  string `json:"o"`
+	}
+
+	if q.Cursor != "" {
+		var c cursor
+		if err := httputil.DecodeCursor(q.Cursor, &c); err != nil {
+			return nil, err
+		}
+		if c.Order != OrderAsc && c.Order != OrderDesc {
+			return nil, httputil.ErrInvalidCursor
+		}
+		q.After, q.Before, q.Order = c.After, c.Before, c.Order
+	}
+	if q.Order == "" {
+		q.Order = OrderAsc
+	}
+	if q.Order != OrderAsc && q.Order != OrderDesc {
+		return nil, ErrInvalidOrder
+	}
+
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	// Join with the Application model to filter by token
-	if err := s.db.Table("messages").
-		Select("messages.*").
-		Joins("JOIN chats ON chats.id = messages.chat_id").
-		Joins("JOIN applications ON applications.id = chats.application_id").
-		Where("applications.token = ? AND chats.chat_number = ?", token, chatNumber).
-		Find(&messages).Error; err != nil {
+	query := s.db.WithContext(ctx).Where("chat_id = ?", chat.ChatID)
+	if q.After > 0 {
+		query = query.Where("message_number > ?", q.After)
+	}
+	if q.Before > 0 {
+		query = query.Where("message_number < ?", q.Before)
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	var messages []models.Message
+	if err := query.Order("message_number " + q.Order).Limit(q.Limit + 1).Find(&messages).Error; err != nil {
 		return nil, err
 	}
 
-	return messages, nil
+	page := &MessagePage{Messages: messages}
+	if len(messages) > q.Limit {
+		page.Messages = messages[:q.Limit]
+		last := page.Messages[q.Limit-1].MessageNumber
+
+		next := cursor{After: q.After, Before: q.Before, Order: q.Order}
+		if q.Order == OrderAsc {
+			next.After = last
+		} else {
+			next.Before = last
+		}
+		page.NextCursor = httputil.EncodeCursor(next)
+	}
+
+	return page, nil
 }
 
-func (s *MessageService) SearchMessages(ctx context.Context, chatID uint, query string) ([]models.Message, error) {
-	// Construct the search body with partial matching capabilities
+func (s *MessageService) SearchMessages(ctx context.Context, token string, chatNumber int, q MessageSearchQuery) (*MessageSearchResult, error) {
+	var after json.RawMessage
+	if q.Cursor != "" {
+		if q.From != 0 {
+			return nil, ErrInvalidSearchPage
+		}
+		cursor, err := decodeSearchCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.Sort, q.Order, after = cursor.Sort, cursor.Order, cursor.After
+	}
+	if q.Mode == "" {
+		q.Mode = SearchPrefix
+	}
+	if q.Sort == "" {
+		q.Sort = SortRelevance
+	}
+	if q.Order == "" {
+		q.Order = defaultSearchOrder(q.Sort)
+	}
+	if q.Size <= 0 {
+		q.Size = DefaultSearchSize
+	}
+	// One extra hit tells whether another page follows
+	if q.From < 0 || q.From+q.Size+1 > maxSearchWindow {
+		return nil, ErrInvalidSearchPage
+	}
+
+	subfields := s.subfields.Available(ctx)
+	match, err := matchQuery(q.Mode, q.Query, subfields)
+	if err != nil {
+		return nil, err
+	}
+	sort, err := sortClause(q.Sort, q.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.resolver.Resolve(ctx, token, chatNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct the search body matching the query the way the mode asks
 	searchBody := map[string]interface{}{
 		"query": map[string]interface{}{
 			"bool": map[string]interface{}{
+				// Scope to the chat resolved from the caller's token so tenants
+				// never see each other's messages. Every document carries the
+				// chat ID, including those indexed before application_id and
+				// chat_number were added.
 				"filter": []interface{}{
 					map[string]interface{}{
 						"term": map[string]interface{}{
-							"ChatID": chatID,
-						},
-					},
-				},
-				"must": []interface{}{
-					map[string]interface{}{
-						"match": map[string]interface{}{
-							"Body": map[string]interface{}{
-								"query":     query,
-								"fuzziness": "AUTO", // Optional: for fuzzy matching
-							},
+							"chat_id": chat.ChatID,
 						},
 					},
 				},
+				"must": []interface{}{match},
 			},
 		},
+		"from":             q.From,
+		"size":             q.Size + 1,
+		"sort":             sort,
+		"track_total_hits": true,
+		"highlight": map[string]interface{}{
+			"encoder":             "html",
+			"fragment_size":       150,
+			"number_of_fragments": 3,
+			"fields": map[string]interface{}{
+				highlightField(q.Mode, subfields): map[string]interface{}{},
+			},
+		},
+	}
+	if after != nil {
+		searchBody["search_after"] = after
 	}
 
 	searchJSON, err := json.Marshal(searchBody)
@@ -110,7 +346,7 @@ func (s *MessageService) SearchMessages(ctx context.Context, chatID uint, query
 
 	res, err := s.es.Search(
 		s.es.Search.WithContext(ctx),
-		s.es.Search.WithIndex("messages"),
+		s.es.Search.WithIndex(search.Alias),
 		s.es.Search.WithBody(strings.NewReader(string(searchJSON))))
 
 	if err != nil {
@@ -118,14 +354,20 @@ func (s *MessageService) SearchMessages(ctx context.Context, chatID uint, query
 	}
 	defer res.Body.Close()
 
+	if res.IsError() {
+		return nil, fmt.Errorf("error executing search: %s", res.String())
+	}
+
 	var result struct {
 		Hits struct {
 			Total struct {
-				Value    int    `json:"value"`
-				Relation string `json:"relation"`
+				Value int64 `json:"value"`
 			} `json:"total"`
 			Hits []struct {
-				Source models.Message `json:"_source"`
+				Source    search.Document     `json:"_source"`
+				Score     *float64            `json:"_score"`
+				Sort      json.RawMessage     `json:"sort"`
+				Highlight map[string][]string `json:"highlight"`
 			} `json:"hits"`
 		} `json:"hits"`
 	}
@@ -134,10 +376,24 @@ func (s *MessageService) SearchMessages(ctx context.Context, chatID uint, query
 		return nil, fmt.Errorf("error decoding search results: %w", err)
 	}
 
-	messages := make([]models.Message, len(result.Hits.Hits))
-	for i, hit := range result.Hits.Hits {
-		messages[i] = hit.Source
+	hits := result.Hits.Hits
+	page := &MessageSearchResult{Total: result.Hits.Total.Value}
+	if len(hits) > q.Size {
+		hits = hits[:q.Size]
+		next := searchCursor{Sort: q.Sort, Order: q.Order, After: hits[q.Size-1].Sort}
+		page.NextCursor = httputil.EncodeCursor(next)
+	}
+
+	page.Hits = make([]MessageSearchHit, len(hits))
+	for i, hit := range hits {
+		page.Hits[i] = MessageSearchHit{
+			Message:    hit.Source.Message(),
+			Highlights: hit.Highlight[highlightField(q.Mode, subfields)],
+		}
+		if q.Sort == SortRelevance {
+			page.Hits[i].Score = hit.Score
+		}
 	}
 
-	return messages, nil
+	return page, nil
 }
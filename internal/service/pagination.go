@@ -0,0 +1,31 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"errors"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+var ErrInvalidOrder = errors.New("order must be asc or desc")
+
+// MessagePageQuery selects a page of messages. After and Before bound the
+// message numbers exclusively, Order is asc (oldest first) or desc (newest
+// first). A Cursor from a previous page replaces After, Before and Order.
+type MessagePageQuery struct {
+	After  int
+	Before int
+	Limit  int
+	Order  string
+	Cursor string
+}
+
+// MessagePage is one page of messages. NextCursor is empty on the last
+// page.
+type MessagePage struct {
+	Messages   []models.Message
+	NextCursor string
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrApplicationNotFound = errors.New("application not found")
+	ErrChatNotFound        = errors.New("chat not found")
+	// ErrChatPending is returned for a chat whose number was handed out but
+	// which the worker has not written yet.
+	ErrChatPending = errors.New("chat is still being created")
+)
+
+const chatIDCacheTTL = 24 * time.Hour
+
+// ResolvedChat identifies a chat by its internal IDs.
+type ResolvedChat struct {
+	ApplicationID uint
+	ChatID        uint
+	ChatNumber    int
+}
+
+// ChatResolver maps an application token and chat number to the chat's
+// primary key, caching hits in Redis. The cache is keyed by the stable
+// application ID, so rotating a token leaves it intact.
+type ChatResolver struct {
+	db     *gorm.DB
+	redis  *redis.Client
+	tokens *TokenResolver
+}
+
+func NewChatResolver(db *gorm.DB, redis *redis.Client, tokens *TokenResolver) *ChatResolver {
+	return &ChatResolver{db: db, redis: redis, tokens: tokens}
+}
+
+func chatIDCacheKey(appID uint, chatNumber int) string {
+	return fmt.Sprintf("chat_id:%d:%d", appID, chatNumber)
+}
+
+func (r *ChatResolver) Resolve(ctx context.Context, token string, chatNumber int) (*ResolvedChat, error) {
+	appID, err := r.tokens.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := chatIDCacheKey(appID, chatNumber)
+
+	var chat ResolvedChat
+	if cached, err := r.redis.Get(ctx, cacheKey).Result(); err == nil {
+		if _, err := fmt.Sscanf(cached, "%d:%d", &chat.ApplicationID, &chat.ChatID); err == nil {
+			chat.ChatNumber = chatNumber
+			return &chat, nil
+		}
+	}
+
+	err = r.db.WithContext(ctx).Table("chats").
+		Select("chats.application_id, chats.id AS chat_id, chats.chat_number").
+		Joins("JOIN applications ON applications.id = chats.application_id").
+		Where("chats.application_id = ? AND chats.chat_number = ?", appID, chatNumber).
+		Where("applications.deleted_at IS NULL AND chats.deleted_at IS NULL").
+		Take(&chat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, r.missingChatError(ctx, appID, chatNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.redis.Set(ctx, cacheKey, fmt.Sprintf("%d:%d", chat.ApplicationID, chat.ChatID), chatIDCacheTTL)
+
+	return &chat, nil
+}
+
+// Forget drops the cached IDs of a chat.
+func (r *ChatResolver) Forget(ctx context.Context, appID uint, chatNumber int) error {
+	return r.redis.Del(ctx, chatIDCacheKey(appID, chatNumber)).Err()
+}
+
+// ForgetApplication drops the cached IDs of every chat of an application.
+func (r *ChatResolver) ForgetApplication(ctx context.Context, appID uint) error {
+	iter := r.redis.Scan(ctx, 0, fmt.Sprintf("chat_id:%d:*", appID), 500).Iterator()
+	for iter.Next(ctx) {
+		if err := r.redis.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// missingChatError tells apart a deleted application, a chat that is still
+// queued for creation and a chat that does not exist or was deleted.
+func (r *ChatResolver) missingChatError(ctx context.Context, appID uint, chatNumber int) error {
+	var apps int64
+	if err := r.db.WithContext(ctx).Model(&models.Application{}).Where("id = ?", appID).Count(&apps).Error; err != nil {
+		return err
+	}
+	if apps == 0 {
+		return ErrApplicationNotFound
+	}
+
+	var deleted int64
+	if err := r.db.WithContext(ctx).Unscoped().Model(&models.Chat{}).
+		Where("application_id = ? AND chat_number = ?", appID, chatNumber).
+		Count(&deleted).Error; err != nil {
+		return err
+	}
+	if deleted > 0 {
+		return ErrChatNotFound
+	}
+
+	lastIssued, err := r.redis.Get(ctx, chatCounterKey(appID)).Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if chatNumber > 0 && chatNumber <= lastIssued {
+		return ErrChatPending
+	}
+
+	return ErrChatNotFound
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"chat-system/internal/pkg/httputil"
+	"chat-system/internal/search"
+	"encoding/json"
+	"errors"
+)
+
+// Search modes decide how the query text has to appear in a message body.
+const (
+	// SearchPrefix matches words starting with the query's words, e.g.
+	// "hel" finds "hello".
+	SearchPrefix = "prefix"
+	// SearchSubstring matches the query's words anywhere inside words,
+	// e.g. "ell" finds "hello".
+	SearchSubstring = "substring"
+	// SearchPhrase matches the query's words in order, as a phrase.
+	SearchPhrase = "phrase"
+	// SearchFuzzy matches words within a few typos of the query's words.
+	SearchFuzzy = "fuzzy"
+)
+
+// Search results are sorted by relevance, best match first, or by message
+// number or creation time in the requested order.
+const (
+	SortRelevance     = "relevance"
+	SortMessageNumber = "message_number"
+	SortCreatedAt     = "created_at"
+)
+
+const (
+	DefaultSearchSize = 10
+
+	// maxSearchWindow is how deep from and size can reach into the results,
+	// the index's max_result_window. The cursor pages past it.
+	maxSearchWindow = 10000
+)
+
+var (
+	ErrInvalidSearchMode  = errors.New("mode must be prefix, substring, phrase or fuzzy")
+	ErrInvalidSearchSort  = errors.New("sort must be relevance, message_number or created_at")
+	ErrInvalidSearchPage  = errors.New("from + size must stay below 10000 and from cannot be combined with a cursor; page further with the cursor")
+	ErrInvalidSearchOrder = errors.New("order cannot be combined with sort=relevance")
+)
+
+// MessageSearchQuery selects a page of the messages of a chat matching
+// Query. Mode is one of the Search* modes and defaults to SearchPrefix. Sort
+// is one of the Sort* orders and defaults to SortRelevance. Order (asc or
+// desc) only applies to sorting by message number, where it defaults to
+// asc, or by creation time, where it defaults to desc; it must be empty
+// when sorting by relevance. A Cursor from a previous page replaces Sort,
+// Order and From, while Query and Mode have to be passed again.
+type MessageSearchQuery struct {
+	Query  string
+	Mode   string
+	Sort   string
+	Order  string
+	From   int
+	Size   int
+	Cursor string
+}
+
+// MessageSearchHit is a message matching a search. Score is only set when
+// sorting by relevance. Highlights are HTML-escaped fragments of the body
+// with the matched words wrapped in <em> tags.
+type MessageSearchHit struct {
+	Message    models.Message
+	Score      *float64
+	Highlights []string
+}
+
+// MessageSearchResult is one page of search results. Total counts every
+// matching message, not only those on the page. NextCursor is empty on the
+// last page.
+type MessageSearchResult struct {
+	Hits       []MessageSearchHit
+	Total      int64
+	NextCursor string
+}
+
+// searchCursor carries the sort values of the last hit on a page, which the
+// index continues after. It is encoded with httputil.EncodeCursor.
+type searchCursor struct {
+	Sort  string          `json:"s"`
+	Order string          `json:"o"`
+	After json.RawMessage `json:"a"`
+}
+
+func decodeSearchCursor(value string) (searchCursor, error) {
+	var c searchCursor
+	if err := httputil.DecodeCursor(value, &c); err != nil {
+		return c, err
+	}
+	if len(c.After) == 0 {
+		return c, httputil.ErrInvalidCursor
+	}
+	if _, err := sortClause(c.Sort, c.Order); err != nil {
+		return c, httputil.ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// defaultSearchOrder is the order of a sort when none is given: newest
+// first by creation time, lowest first by message number.
+func defaultSearchOrder(sort string) string {
+	switch sort {
+	case SortRelevance:
+		return ""
+	case SortCreatedAt:
+		return OrderDesc
+	default:
+		return OrderAsc
+	}
+}
+
+// sortClause builds the sort of a search. Every sort ends on the message
+// number, which is unique within a chat, so the cursor has a well-defined
+// position to continue after.
+func sortClause(sort, order string) ([]interface{}, error) {
+	if sort == SortRelevance {
+		if order != "" {
+			return nil, ErrInvalidSearchOrder
+		}
+	} else if order != OrderAsc && order != OrderDesc {
+		return nil, ErrInvalidOrder
+	}
+
+	switch sort {
+	case SortRelevance:
+		return []interface{}{
+			map[string]interface{}{"_score": OrderDesc},
+			map[string]interface{}{"message_number": OrderAsc},
+		}, nil
+	case SortMessageNumber:
+		return []interface{}{map[string]interface{}{"message_number": order}}, nil
+	case SortCreatedAt:
+		return []interface{}{
+			map[string]interface{}{"created_at": order},
+			map[string]interface{}{"message_number": order},
+		}, nil
+	default:
+		return nil, ErrInvalidSearchSort
+	}
+}
+
+// highlightField is the field whose matches are highlighted for a mode. The
+// n-gram subfields keep the offsets of whole words, so a prefix or
+// substring match highlights the word it is part of.
+func highlightField(mode string, subfields bool) string {
+	if !subfields {
+		return "body"
+	}
+
+	switch mode {
+	case SearchPrefix:
+		return search.BodyPrefix
+	case SearchSubstring:
+		return search.BodyInfix
+	default:
+		return "body"
+	}
+}
+
+// Boosts rank closer matches first: the exact phrase, then all of its
+// words, then words merely starting with or containing them.
+const (
+	phraseBoost = 4
+	wordsBoost  = 3
+	prefixBoost = 2
+)
+
+// matchQuery builds the query matching text in a message body according to
+// mode. Partial modes also try the closer kinds of match, so a message
+// containing the exact words ranks above one that only shares fragments.
+// Without the n-gram subfields they only match whole words.
+func matchQuery(mode, text string, subfields bool) (map[string]interface{}, error) {
+	phrase := map[string]interface{}{
+		"match_phrase": map[string]interface{}{
+			"body": map[string]interface{}{"query": text, "boost": phraseBoost},
+		},
+	}
+	words := match("body", text, wordsBoost)
+
+	var should []interface{}
+	switch {
+	case !subfields && (mode == SearchPrefix || mode == SearchSubstring):
+		should = []interface{}{phrase, words}
+	case mode == SearchPrefix:
+		should = []interface{}{phrase, words, match(search.BodyPrefix, text, 1)}
+	case mode == SearchSubstring:
+		should = []interface{}{phrase, words, match(search.BodyPrefix, text, prefixBoost), match(search.BodyInfix, text, 1)}
+	case mode == SearchPhrase:
+		return phrase, nil
+	case mode == SearchFuzzy:
+		fuzzy := map[string]interface{}{
+			"match": map[string]interface{}{
+				"body": map[string]interface{}{"query": text, "fuzziness": "AUTO"},
+			},
+		}
+		should = []interface{}{words, fuzzy}
+	default:
+		return nil, ErrInvalidSearchMode
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}, nil
+}
+
+// match requires every word of text to match field.
+func match(field, text string, boost float64) map[string]interface{} {
+	return map[string]interface{}{
+		"match": map[string]interface{}{
+			field: map[string]interface{}{"query": text, "operator": "and", "boost": boost},
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"chat-system/internal/db/models"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// tokenCacheTTL bounds how long a resolved token is trusted without asking
+// MySQL. Tokens in their grace period are cached no longer than they live.
+const tokenCacheTTL = 10 * time.Minute
+
+// TokenResolver maps application tokens, current or still in their grace
+// period after a rotation, to the application's stable ID.
+type TokenResolver struct {
+	db    *gorm.DB
+	redis *redis.Client
+}
+
+func NewTokenResolver(db *gorm.DB, redis *redis.Client) *TokenResolver {
+	return &TokenResolver{db: db, redis: redis}
+}
+
+func tokenCacheKey(token string) string {
+	return fmt.Sprintf("app_token:%s", token)
+}
+
+// Resolve returns the ID of the application a token belongs to.
+func (r *TokenResolver) Resolve(ctx context.Context, token string) (uint, error) {
+	if cached, err := r.redis.Get(ctx, tokenCacheKey(token)).Result(); err == nil {
+		if id, err := strconv.ParseUint(cached, 10, 64); err == nil {
+			return uint(id), nil
+		}
+	}
+
+	var app models.Application
+	err := r.db.WithContext(ctx).Select("id").Where("token = ?", token).Take(&app).Error
+	if err == nil {
+		r.redis.Set(ctx, tokenCacheKey(token), app.ID, tokenCacheTTL)
+		return app.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var previous models.ApplicationToken
+	err = r.db.WithContext(ctx).
+		Joins("JOIN applications ON applications.id = application_tokens.application_id").
+		Where("application_tokens.token = ? AND application_tokens.revoked_at IS NULL", token).
+		Where("application_tokens.expires_at > ? AND applications.deleted_at IS NULL", time.Now().UTC()).
+		Take(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrApplicationNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := min(tokenCacheTTL, time.Until(previous.ExpiresAt))
+	if ttl > 0 {
+		r.redis.Set(ctx, tokenCacheKey(token), previous.ApplicationID, ttl)
+	}
+	return previous.ApplicationID, nil
+}
+
+// Forget drops cached lookups so revoked tokens stop working at once.
+func (r *TokenResolver) Forget(ctx context.Context, tokens ...string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(tokens))
+	for i, token := range tokens {
+		keys[i] = tokenCacheKey(token)
+	}
+	return r.redis.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"bytes"
+	"chat-system/internal/db"
+	"chat-system/internal/db/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm/clause"
+)
+
+type messageJob struct {
+	qm      *queue.QueuedMessage
+	message *models.Message
+}
+
+// processMessageBatch persists a batch of message_creation jobs with one
+// multi-row insert and one bulk index request. Jobs are acked or failed
+// individually so one bad message does not sink the rest of the batch.
+func (c *consumer) processMessageBatch(ctx context.Context, qms []*queue.QueuedMessage) {
+	jobs := make([]messageJob, 0, len(qms))
+	for _, qm := range qms {
+		var data struct {
+			ChatID        uint   `json:"chat_id"`
+			MessageNumber int    `json:"message_number"`
+			Body          string `json:"body"`
+		}
+
+		if err := json.Unmarshal(qm.Payload, &data); err != nil {
+			c.fail(ctx, qm, queue.Permanent(fmt.Errorf("unmarshaling message creation payload: %w", err)))
+			continue
+		}
+
+		jobs = append(jobs, messageJob{
+			qm: qm,
+			message: &models.Message{
+				ChatID:        data.ChatID,
+				MessageNumber: data.MessageNumber,
+				Body:          data.Body,
+			},
+		})
+	}
+
+	jobs = c.insertMessages(ctx, jobs)
+	jobs = c.refreshRetried(ctx, jobs)
+	jobs = c.indexMessages(ctx, jobs)
+
+	done := make([]*queue.QueuedMessage, len(jobs))
+	for i, job := range jobs {
+		done[i] = job.qm
+	}
+	if err := c.queue.AckAll(ctx, c.id, done); err != nil {
+		log.Printf("Error acknowledging %d message jobs: %v", len(done), err)
+	}
+}
+
+// insertMessages writes all messages in a single INSERT. If the database
+// rejects it, the rows are retried one by one to isolate the bad ones. A
+// message that already exists, e.g. because a retried job was stored
+// before its indexing failed, counts as stored. It returns the jobs whose
+// message was stored. Only rows that were actually inserted are added to
+// the chats' message counts.
+func (c *consumer) insertMessages(ctx context.Context, jobs []messageJob) []messageJob {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	messages := make([]*models.Message, len(jobs))
+	for i, job := range jobs {
+		messages[i] = job.message
+	}
+
+	result := db.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&messages)
+	err := result.Error
+	if err == nil {
+		c.countInserted(ctx, jobs, result.RowsAffected)
+		return jobs
+	}
+	if len(jobs) > 1 {
+		log.Printf("Batch insert of %d messages failed, inserting individually: %v", len(jobs), err)
+	}
+
+	// A message that fails holds back the later messages of its chat, so
+	// they are still stored in message number order
+	stored := make([]messageJob, 0, len(jobs))
+	inserted := map[uint]int64{}
+	for _, job := range jobs {
+		if c.hold(ctx, job.qm) {
+			continue
+		}
+		job.message.ID = 0
+		result := db.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(job.message)
+		if result.Error != nil {
+			c.fail(ctx, job.qm, fmt.Errorf("creating message: %w", result.Error))
+			continue
+		}
+		inserted[job.message.ChatID] += result.RowsAffected
+		stored = append(stored, job)
+	}
+	c.counts.addMessages(ctx, inserted)
+	return stored
+}
+
+// countInserted records the messages a multi-row insert added. When some
+// rows were duplicates the insert does not say which, so the chats involved
+// are recounted instead.
+func (c *consumer) countInserted(ctx context.Context, jobs []messageJob, rowsAffected int64) {
+	perChat := map[uint]int64{}
+	for _, job := range jobs {
+		perChat[job.message.ChatID]++
+	}
+
+	if rowsAffected == int64(len(jobs)) {
+		c.counts.addMessages(ctx, perChat)
+		return
+	}
+
+	chatIDs := make([]uint, 0, len(perChat))
+	for chatID := range perChat {
+		chatIDs = append(chatIDs, chatID)
+	}
+	c.counts.recountChats(ctx, chatIDs)
+}
+
+// refreshRetried replaces the payload of retried jobs with the stored row.
+// A retry can run after later edits or a deletion of the same message, and
+// indexing the original body would undo them. Retried jobs for deleted
+// messages are acked without indexing.
+func (c *consumer) refreshRetried(ctx context.Context, jobs []messageJob) []messageJob {
+	fresh := make([]messageJob, 0, len(jobs))
+	for _, job := range jobs {
+		if job.qm.Attempts == 0 {
+			fresh = append(fresh, job)
+			continue
+		}
+
+		var stored models.Message
+		err := db.GormDB.WithContext(ctx).Unscoped().
+			Where("chat_id = ? AND message_number = ?", job.message.ChatID, job.message.MessageNumber).
+			Take(&stored).Error
+		if err != nil {
+			c.fail(ctx, job.qm, fmt.Errorf("loading stored message: %w", err))
+			continue
+		}
+
+		if stored.DeletedAt.Valid {
+			if err := c.queue.Ack(ctx, c.id, job.qm); err != nil {
+				log.Printf("Error acknowledging job %s: %v", job.qm.ID, err)
+			}
+			continue
+		}
+
+		job.message = &stored
+		fresh = append(fresh, job)
+	}
+	return fresh
+}
+
+// indexMessages sends the stored messages to Elasticsearch through the
+// _bulk API and returns the jobs whose document was indexed.
+func (c *consumer) indexMessages(ctx context.Context, jobs []messageJob) []messageJob {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	chats, err := loadChats(ctx, jobs)
+	if err != nil {
+		c.failAll(ctx, jobs, fmt.Errorf("loading chats for indexing: %w", err))
+		return nil
+	}
+
+	var body bytes.Buffer
+	for _, job := range jobs {
+		meta := map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index": search.Alias,
+				"_id":    search.DocumentID(job.message.ChatID, job.message.MessageNumber),
+			},
+		}
+
+		metaJSON, err := json.Marshal(meta)
+		if err != nil {
+			c.failAll(ctx, jobs, fmt.Errorf("marshaling bulk metadata: %w", err))
+			return nil
+		}
+		messageJSON, err := json.Marshal(search.NewDocument(*job.message, chats[job.message.ChatID]))
+		if err != nil {
+			c.failAll(ctx, jobs, fmt.Errorf("marshaling message for Elasticsearch: %w", err))
+			return nil
+		}
+
+		body.Write(metaJSON)
+		body.WriteByte('\n')
+		body.Write(messageJSON)
+		body.WriteByte('\n')
+	}
+
+	res, err := c.es.Bulk(&body, c.es.Bulk.WithContext(ctx))
+	if err != nil {
+		c.failAll(ctx, jobs, fmt.Errorf("bulk indexing messages in Elasticsearch: %w", err))
+		return nil
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		c.failAll(ctx, jobs, fmt.Errorf("bulk indexing messages in Elasticsearch: %s", res.String()))
+		return nil
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		c.failAll(ctx, jobs, fmt.Errorf("decoding bulk response: %w", err))
+		return nil
+	}
+
+	if !result.Errors {
+		return jobs
+	}
+
+	// Items come back in request order, one per action
+	indexed := make([]messageJob, 0, len(jobs))
+	for i, job := range jobs {
+		if i >= len(result.Items) {
+			c.fail(ctx, job.qm, fmt.Errorf("missing bulk response item"))
+			continue
+		}
+		item := result.Items[i]["index"]
+		if item.Status >= 300 {
+			c.fail(ctx, job.qm, fmt.Errorf("indexing message %s in Elasticsearch: status %d: %s", item.ID, item.Status, item.Error))
+			continue
+		}
+		indexed = append(indexed, job)
+	}
+	return indexed
+}
+
+func (c *consumer) failAll(ctx context.Context, jobs []messageJob, err error) {
+	for _, job := range jobs {
+		c.fail(ctx, job.qm, err)
+	}
+}
+
+// loadChats fetches the chats referenced by a batch in a single query.
+func loadChats(ctx context.Context, jobs []messageJob) (map[uint]models.Chat, error) {
+	ids := make([]uint, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.message.ChatID)
+	}
+
+	var chats []models.Chat
+	if err := db.GormDB.WithContext(ctx).Unscoped().
+		Select("id, application_id, chat_number").
+		Where("id IN ?", ids).
+		Find(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]models.Chat, len(chats))
+	for _, chat := range chats {
+		byID[chat.ID] = chat
+	}
+	return byID, nil
+}
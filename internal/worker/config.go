@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"chat-system/internal/reindex"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	// Concurrency is the number of consumers this process runs. Each one
+	// owns a share of the queue shards.
+	Concurrency int
+	// BatchSize is the maximum number of jobs drained from the queue and
+	// written together.
+	BatchSize int
+	// BatchWait is how long to keep filling a batch after its first job
+	// arrived.
+	BatchWait time.Duration
+	// CountFlushInterval is how often pending chats_count and
+	// messages_count deltas are written to MySQL.
+	CountFlushInterval time.Duration
+	// RecountInterval is how often every count is recomputed from scratch
+	// to repair drift. Zero disables the repair job.
+	RecountInterval time.Duration
+	// VerifyInterval is how often the search index is compared with MySQL.
+	// Zero disables the check.
+	VerifyInterval time.Duration
+	// VerifySampleSize is how many messages and documents each check looks
+	// up at random on the other side.
+	VerifySampleSize int
+	// VerifyRepair makes the check fix the drift it finds rather than only
+	// report it.
+	VerifyRepair bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Concurrency: 4,
+		BatchSize:   100,
+		BatchWait:   50 * time.Millisecond,
+
+		CountFlushInterval: 2 * time.Second,
+		RecountInterval:    6 * time.Hour,
+
+		VerifyInterval:   time.Hour,
+		VerifySampleSize: reindex.DefaultSampleSize,
+		VerifyRepair:     true,
+	}
+}
+
+// ConfigFromEnv reads WORKER_* environment variables, falling back to the
+// defaults for anything unset or invalid.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if n, ok := envInt("WORKER_CONCURRENCY"); ok && n > 0 {
+		cfg.Concurrency = n
+	}
+	if n, ok := envInt("WORKER_BATCH_SIZE"); ok && n > 0 {
+		cfg.BatchSize = n
+	}
+	if ms, ok := envInt("WORKER_BATCH_WAIT_MS"); ok && ms >= 0 {
+		cfg.BatchWait = time.Duration(ms) * time.Millisecond
+	}
+
+	if ms, ok := envInt("WORKER_COUNT_FLUSH_MS"); ok && ms > 0 {
+		cfg.CountFlushInterval = time.Duration(ms) * time.Millisecond
+	}
+	if d, err := time.ParseDuration(os.Getenv("WORKER_RECOUNT_INTERVAL")); err == nil && d >= 0 {
+		cfg.RecountInterval = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("WORKER_VERIFY_INTERVAL")); err == nil && d >= 0 {
+		cfg.VerifyInterval = d
+	}
+	if n, ok := envInt("WORKER_VERIFY_SAMPLE_SIZE"); ok && n >= 0 && n <= reindex.MaxSampleSize {
+		cfg.VerifySampleSize = n
+	}
+	if repair, err := strconv.ParseBool(os.Getenv("WORKER_VERIFY_REPAIR")); err == nil {
+		cfg.VerifyRepair = repair
+	}
+
+	return cfg
+}
+
+func envInt(key string) (int, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
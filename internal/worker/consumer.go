@@ -0,0 +1,321 @@
+package worker
+
+import (
+	"chat-system/internal/db"
+	"chat-system/internal/db/models"
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"chat-system/internal/service"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"gorm.io/gorm/clause"
+)
+
+// consumer is one member of the worker pool. It holds leases on a share of
+// the queue shards and is the only one taking jobs from them, so jobs for
+// the same chat are always persisted in the order they were queued.
+type consumer struct {
+	id     string
+	cfg    Config
+	queue  *queue.MessageQueue
+	es     *elasticsearch.Client
+	counts *countTracker
+
+	// Used by deletion jobs
+	jobs     *jobs.Store
+	counters *service.CounterService
+	resolver *service.ChatResolver
+
+	mu         sync.Mutex
+	shards     []int
+	balancedAt time.Time
+
+	// failedKeys are the keys of the jobs that failed in the current batch
+	failedKeys map[uint]bool
+}
+
+func (c *consumer) run(ctx context.Context) {
+	// Jobs already taken off the queue are finished even once ctx is
+	// cancelled, so shutdown never abandons a half-written batch.
+	jobCtx := context.WithoutCancel(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.unregister()
+			return
+		default:
+		}
+
+		if time.Since(c.balancedAt) >= rebalanceInterval {
+			c.rebalance(ctx)
+		}
+
+		batch, err := c.nextBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error popping from queue: %v", err)
+		}
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(idlePollInterval):
+			}
+			continue
+		}
+
+		c.processBatch(jobCtx, batch)
+	}
+}
+
+func (c *consumer) ownedShards() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.shards...)
+}
+
+func (c *consumer) dropShards(lost []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := c.shards[:0]
+	for _, shard := range c.shards {
+		isLost := false
+		for _, l := range lost {
+			if shard == l {
+				isLost = true
+				break
+			}
+		}
+		if !isLost {
+			kept = append(kept, shard)
+		}
+	}
+	c.shards = kept
+}
+
+// rebalance releases or acquires shard leases so that every live consumer
+// across all worker processes holds roughly the same number of shards. It
+// runs between batches, when the consumer has nothing in flight.
+func (c *consumer) rebalance(ctx context.Context) {
+	c.balancedAt = time.Now()
+
+	active, err := c.queue.ActiveConsumers(ctx)
+	if err != nil || active < 1 {
+		active = 1
+	}
+	fairShare := (c.queue.Shards + int(active) - 1) / int(active)
+
+	owned := c.ownedShards()
+	if len(owned) > fairShare {
+		extra := owned[fairShare:]
+		for _, shard := range extra {
+			if err := c.queue.ReleaseShard(ctx, c.id, shard); err != nil {
+				log.Printf("Error releasing shard %d: %v", shard, err)
+			}
+		}
+		c.dropShards(extra)
+		return
+	}
+
+	isOwned := make(map[int]bool, len(owned))
+	for _, shard := range owned {
+		isOwned[shard] = true
+	}
+
+	var acquired []int
+	offset := rand.Intn(c.queue.Shards)
+	for i := 0; i < c.queue.Shards && len(owned)+len(acquired) < fairShare; i++ {
+		shard := (offset + i) % c.queue.Shards
+		if isOwned[shard] {
+			continue
+		}
+		ok, err := c.queue.AcquireShard(ctx, c.id, shard, heartbeatTTL)
+		if err != nil {
+			log.Printf("Error acquiring shard %d: %v", shard, err)
+			return
+		}
+		if ok {
+			acquired = append(acquired, shard)
+		}
+	}
+	if len(acquired) == 0 {
+		return
+	}
+
+	// A shard is only free once its previous owner's lease expired, by which
+	// time its heartbeat has too. Hand its in-flight jobs back first so they
+	// run before anything queued after them.
+	if _, err := c.queue.ReapOrphans(ctx); err != nil {
+		log.Printf("Error reaping orphaned jobs: %v", err)
+	}
+
+	c.mu.Lock()
+	c.shards = append(c.shards, acquired...)
+	c.mu.Unlock()
+}
+
+// nextBatch takes jobs round-robin from the owned shards until the batch is
+// full or BatchWait has passed since the first job arrived.
+func (c *consumer) nextBatch(ctx context.Context) ([]*queue.QueuedMessage, error) {
+	shards := c.ownedShards()
+	if len(shards) == 0 {
+		return nil, nil
+	}
+
+	var batch []*queue.QueuedMessage
+	var deadline time.Time
+	for len(batch) < c.cfg.BatchSize {
+		found := false
+		for _, shard := range shards {
+			if len(batch) >= c.cfg.BatchSize {
+				break
+			}
+			qm, err := c.queue.Dequeue(ctx, c.id, shard)
+			if err != nil {
+				return batch, err
+			}
+			if qm != nil {
+				batch = append(batch, qm)
+				found = true
+			}
+		}
+
+		if len(batch) == 0 {
+			return nil, nil
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(c.cfg.BatchWait)
+		}
+		if found {
+			continue
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		time.Sleep(min(remaining, batchPollInterval))
+	}
+
+	return batch, nil
+}
+
+// processBatch runs the jobs of a batch in queue order. Consecutive
+// message_creation jobs are written together; any other job first flushes
+// the messages queued before it, so an edit never runs ahead of the
+// message it changes. Once a job is failed for a retry, the later jobs of
+// the batch with the same key are held behind it.
+func (c *consumer) processBatch(ctx context.Context, batch []*queue.QueuedMessage) {
+	c.failedKeys = map[uint]bool{}
+
+	var messages []*queue.QueuedMessage
+	for _, qm := range batch {
+		if qm.Type == "message_creation" {
+			if !c.hold(ctx, qm) {
+				messages = append(messages, qm)
+			}
+			continue
+		}
+
+		if len(messages) > 0 {
+			c.processMessageBatch(ctx, messages)
+			messages = nil
+		}
+
+		if c.hold(ctx, qm) {
+			continue
+		}
+		if err := c.process(ctx, qm); err != nil {
+			c.fail(ctx, qm, err)
+			continue
+		}
+		if err := c.queue.Ack(ctx, c.id, qm); err != nil {
+			log.Printf("Error acknowledging job %s: %v", qm.ID, err)
+		}
+	}
+
+	if len(messages) > 0 {
+		c.processMessageBatch(ctx, messages)
+	}
+}
+
+func (c *consumer) fail(ctx context.Context, qm *queue.QueuedMessage, err error) {
+	log.Printf("Error processing %s job %s (attempt %d): %v", qm.Type, qm.ID, qm.Attempts+1, err)
+	if err := c.queue.Fail(ctx, c.id, qm, err); err != nil {
+		log.Printf("Error recording failure of job %s: %v", qm.ID, err)
+	}
+	c.failedKeys[qm.Key] = true
+}
+
+// hold parks a job of the batch if an earlier job with the same key failed
+// and is waiting for its retry. It reports whether the job was held.
+func (c *consumer) hold(ctx context.Context, qm *queue.QueuedMessage) bool {
+	if !c.failedKeys[qm.Key] {
+		return false
+	}
+
+	held, err := c.queue.Hold(ctx, c.id, qm)
+	if err != nil {
+		log.Printf("Error holding job %s: %v", qm.ID, err)
+	}
+	return held
+}
+
+// unregister hands any in-flight jobs and shard leases back on shutdown.
+func (c *consumer) unregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.queue.Unregister(ctx, c.id, c.ownedShards()); err != nil {
+		log.Printf("Error unregistering consumer %s: %v", c.id, err)
+	}
+}
+
+func (c *consumer) process(ctx context.Context, qm *queue.QueuedMessage) error {
+	switch qm.Type {
+	case "chat_creation":
+		return c.processChatCreation(ctx, qm.Payload)
+	case "message_update":
+		return c.processMessageUpdate(ctx, qm.Payload)
+	case "message_deletion":
+		return c.processMessageDeletion(ctx, qm.Payload)
+	case "application_deletion":
+		return c.processApplicationDeletion(ctx, qm)
+	case "chat_deletion":
+		return c.processChatDeletion(ctx, qm)
+	default:
+		return queue.Permanent(fmt.Errorf("unknown job type %q", qm.Type))
+	}
+}
+
+func (c *consumer) processChatCreation(ctx context.Context, payload json.RawMessage) error {
+	var data struct {
+		AppID      uint `json:"app_id"`
+		ChatNumber int  `json:"chat_number"`
+	}
+
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return queue.Permanent(fmt.Errorf("unmarshaling chat creation payload: %w", err))
+	}
+
+	chat := &models.Chat{
+		ApplicationID: data.AppID,
+		ChatNumber:    data.ChatNumber,
+	}
+
+	// A chat that already exists means this job was delivered twice
+	result := db.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(chat)
+	if result.Error != nil {
+		return fmt.Errorf("creating chat: %w", result.Error)
+	}
+	c.counts.addChats(ctx, data.AppID, result.RowsAffected)
+
+	return nil
+}
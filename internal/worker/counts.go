@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"chat-system/internal/db"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	appDeltasKey      = "counts:app_deltas"
+	chatDeltasKey     = "counts:chat_deltas"
+	chatRecountKey    = "counts:chat_recount"
+	recountLockKey    = "counts:recount_lock"
+	recountLockTTL    = time.Hour
+	countFlushTimeout = 30 * time.Second
+)
+
+// releaseRecountLockScript deletes the recount lock only if it still holds
+// the caller's token, so a recount that ran past the lock's TTL cannot
+// release the lock of the worker that took over.
+var releaseRecountLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// takeDeltasScript reads and clears the pending deltas in one step, so
+// increments recorded while a flush runs are left for the next one.
+var takeDeltasScript = redis.NewScript(`
+local apps = redis.call('HGETALL', KEYS[1])
+local chats = redis.call('HGETALL', KEYS[2])
+local recount = redis.call('SMEMBERS', KEYS[3])
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return {apps, chats, recount}
+`)
+
+// countTracker keeps chats_count and messages_count close to real time.
+// Consumers record how many rows they inserted per application and chat in
+// Redis hashes, and the worker periodically adds those deltas to the rows
+// that changed. A crash between an insert and its delta can still leave a
+// count off by a few, which the scheduled full recount repairs.
+type countTracker struct {
+	redis *redis.Client
+}
+
+func newCountTracker(redis *redis.Client) *countTracker {
+	return &countTracker{redis: redis}
+}
+
+// addChats records new chats for an application.
+func (t *countTracker) addChats(ctx context.Context, appID uint, n int64) {
+	if n == 0 {
+		return
+	}
+	if err := t.redis.HIncrBy(ctx, appDeltasKey, strconv.FormatUint(uint64(appID), 10), n).Err(); err != nil {
+		log.Printf("Error recording chat count delta for application %d: %v", appID, err)
+	}
+}
+
+// addMessages records new messages per chat.
+func (t *countTracker) addMessages(ctx context.Context, perChat map[uint]int64) {
+	if len(perChat) == 0 {
+		return
+	}
+
+	pipe := t.redis.Pipeline()
+	for chatID, n := range perChat {
+		if n != 0 {
+			pipe.HIncrBy(ctx, chatDeltasKey, strconv.FormatUint(uint64(chatID), 10), n)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error recording message count deltas: %v", err)
+	}
+}
+
+// recountChats marks chats whose insert outcome is not known row by row, so
+// the next flush counts their messages instead of applying a delta.
+func (t *countTracker) recountChats(ctx context.Context, chatIDs []uint) {
+	if len(chatIDs) == 0 {
+		return
+	}
+
+	members := make([]interface{}, len(chatIDs))
+	for i, id := range chatIDs {
+		members[i] = id
+	}
+	if err := t.redis.SAdd(ctx, chatRecountKey, members...).Err(); err != nil {
+		log.Printf("Error marking chats for recount: %v", err)
+	}
+}
+
+// forgetChat drops pending deltas of a deleted chat.
+func (t *countTracker) forgetChat(ctx context.Context, chatID uint) {
+	field := strconv.FormatUint(uint64(chatID), 10)
+	pipe := t.redis.Pipeline()
+	pipe.HDel(ctx, chatDeltasKey, field)
+	pipe.SRem(ctx, chatRecountKey, field)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error dropping count deltas of chat %d: %v", chatID, err)
+	}
+}
+
+// forgetApplication drops pending deltas of a deleted application.
+func (t *countTracker) forgetApplication(ctx context.Context, appID uint) {
+	if err := t.redis.HDel(ctx, appDeltasKey, strconv.FormatUint(uint64(appID), 10)).Err(); err != nil {
+		log.Printf("Error dropping count deltas of application %d: %v", appID, err)
+	}
+}
+
+// flush applies the pending deltas to MySQL. If the update fails the deltas
+// are put back so nothing is lost.
+func (t *countTracker) flush(ctx context.Context) error {
+	res, err := takeDeltasScript.Run(ctx, t.redis, []string{appDeltasKey, chatDeltasKey, chatRecountKey}).Slice()
+	if err != nil {
+		return err
+	}
+
+	appDeltas := parseDeltas(res[0])
+	chatDeltas := parseDeltas(res[1])
+	recount := parseIDs(res[2])
+	if len(appDeltas) == 0 && len(chatDeltas) == 0 && len(recount) == 0 {
+		return nil
+	}
+
+	// Recounted chats already include whatever their deltas describe
+	for _, id := range recount {
+		delete(chatDeltas, id)
+	}
+
+	err = db.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for id, delta := range appDeltas {
+			if err := tx.Exec("UPDATE applications SET chats_count = chats_count + ? WHERE id = ?", delta, id).Error; err != nil {
+				return err
+			}
+		}
+		for id, delta := range chatDeltas {
+			if err := tx.Exec("UPDATE chats SET messages_count = messages_count + ? WHERE id = ?", delta, id).Error; err != nil {
+				return err
+			}
+		}
+		for _, id := range recount {
+			if err := tx.Exec("UPDATE chats SET messages_count = (SELECT COUNT(*) FROM messages WHERE chat_id = ? AND deleted_at IS NULL) WHERE id = ?", id, id).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.restore(appDeltas, chatDeltas, recount)
+		return err
+	}
+
+	return nil
+}
+
+func (t *countTracker) restore(appDeltas, chatDeltas map[uint]int64, recount []uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := t.redis.Pipeline()
+	for id, delta := range appDeltas {
+		pipe.HIncrBy(ctx, appDeltasKey, strconv.FormatUint(uint64(id), 10), delta)
+	}
+	for id, delta := range chatDeltas {
+		pipe.HIncrBy(ctx, chatDeltasKey, strconv.FormatUint(uint64(id), 10), delta)
+	}
+	for _, id := range recount {
+		pipe.SAdd(ctx, chatRecountKey, id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error restoring count deltas after a failed flush: %v", err)
+	}
+}
+
+// recountAll recomputes every count from scratch. It scans all of messages,
+// so it only runs on the repair schedule, and only on one worker at a time.
+// Deltas recorded while it runs may be applied on top of rows it already
+// counted; the next recount evens that out.
+func (t *countTracker) recountAll(ctx context.Context) error {
+	token := uuid.New().String()
+	acquired, err := t.redis.SetNX(ctx, recountLockKey, token, recountLockTTL).Result()
+	if err != nil || !acquired {
+		return err
+	}
+	defer releaseRecountLockScript.Run(context.Background(), t.redis, []string{recountLockKey}, token)
+
+	// Apply what is pending first so the recount starts from a clean slate
+	if err := t.flush(ctx); err != nil {
+		return fmt.Errorf("flushing deltas before recount: %w", err)
+	}
+
+	if err := db.GormDB.WithContext(ctx).Exec(`
+		UPDATE applications a
+		SET chats_count = (
+			SELECT COUNT(*) FROM chats
+			WHERE application_id = a.id AND deleted_at IS NULL
+		)
+	`).Error; err != nil {
+		return err
+	}
+
+	return db.GormDB.WithContext(ctx).Exec(`
+		UPDATE chats c
+		SET messages_count = (
+			SELECT COUNT(*) FROM messages
+			WHERE chat_id = c.id AND deleted_at IS NULL
+		)
+	`).Error
+}
+
+func parseDeltas(value interface{}) map[uint]int64 {
+	fields, _ := value.([]interface{})
+	deltas := make(map[uint]int64, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		id, err := strconv.ParseUint(fmt.Sprint(fields[i]), 10, 64)
+		if err != nil {
+			continue
+		}
+		delta, err := strconv.ParseInt(fmt.Sprint(fields[i+1]), 10, 64)
+		if err != nil || delta == 0 {
+			continue
+		}
+		deltas[uint(id)] = delta
+	}
+	return deltas
+}
+
+func parseIDs(value interface{}) []uint {
+	members, _ := value.([]interface{})
+	ids := make([]uint, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseUint(fmt.Sprint(member), 10, 64)
+		if err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
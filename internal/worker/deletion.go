@@ -0,0 +1,237 @@
+package worker
+
+import (
+	"chat-system/internal/db"
+	"chat-system/internal/db/models"
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// deleteBatchSize is how many messages one DELETE removes, keeping each
+// statement short enough not to hold locks for long.
+const deleteBatchSize = 1000
+
+// processApplicationDeletion hides an application and all its chats, then
+// queues a chat_deletion job per chat on the chat's own shard so each runs
+// after that chat's pending messages. The last chat to go finishes the
+// application off.
+func (c *consumer) processApplicationDeletion(ctx context.Context, qm *queue.QueuedMessage) (err error) {
+	var data struct {
+		JobID         string `json:"job_id"`
+		ApplicationID uint   `json:"application_id"`
+	}
+
+	if err := json.Unmarshal(qm.Payload, &data); err != nil {
+		return queue.Permanent(fmt.Errorf("unmarshaling application deletion payload: %w", err))
+	}
+	defer c.recordJobError(qm, data.JobID, &err)
+
+	if err := c.jobs.SetStatus(ctx, data.JobID, jobs.StatusRunning, nil); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := db.GormDB.WithContext(ctx).Unscoped().Model(&models.Application{}).
+		Where("id = ? AND deleted_at IS NULL", data.ApplicationID).
+		Update("deleted_at", now).Error; err != nil {
+		return fmt.Errorf("hiding application: %w", err)
+	}
+	if err := db.GormDB.WithContext(ctx).Unscoped().Model(&models.Chat{}).
+		Where("application_id = ? AND deleted_at IS NULL", data.ApplicationID).
+		Update("deleted_at", now).Error; err != nil {
+		return fmt.Errorf("hiding chats: %w", err)
+	}
+
+	var chatIDs []uint
+	if err := db.GormDB.WithContext(ctx).Unscoped().Model(&models.Chat{}).
+		Where("application_id = ?", data.ApplicationID).
+		Pluck("id", &chatIDs).Error; err != nil {
+		return fmt.Errorf("listing chats: %w", err)
+	}
+
+	if err := c.jobs.SetCounter(ctx, data.JobID, "chats_total", int64(len(chatIDs))); err != nil {
+		return err
+	}
+	if len(chatIDs) == 0 {
+		return c.finishApplicationDeletion(ctx, data.JobID, data.ApplicationID)
+	}
+
+	// Re-queueing after a retry is harmless: deleting a chat twice is a no-op
+	for _, chatID := range chatIDs {
+		chatPayload := chatDeletionPayload{JobID: data.JobID, ChatID: chatID, ApplicationID: data.ApplicationID}
+		if err := c.queue.Enqueue(ctx, "chat_deletion", chatID, chatPayload); err != nil {
+			return fmt.Errorf("queueing deletion of chat %d: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// chatDeletionPayload identifies a chat to delete. ApplicationID is set when
+// the chat is deleted as part of its application.
+type chatDeletionPayload struct {
+	JobID         string `json:"job_id"`
+	ChatID        uint   `json:"chat_id"`
+	ApplicationID uint   `json:"application_id,omitempty"`
+}
+
+// processChatDeletion removes a chat's messages in batches, its search
+// documents and Redis keys, and finally the chat row itself.
+func (c *consumer) processChatDeletion(ctx context.Context, qm *queue.QueuedMessage) (err error) {
+	var data chatDeletionPayload
+	if err := json.Unmarshal(qm.Payload, &data); err != nil {
+		return queue.Permanent(fmt.Errorf("unmarshaling chat deletion payload: %w", err))
+	}
+	defer c.recordJobError(qm, data.JobID, &err)
+
+	standalone := data.ApplicationID == 0
+	if standalone {
+		if err := c.jobs.SetStatus(ctx, data.JobID, jobs.StatusRunning, nil); err != nil {
+			return err
+		}
+	}
+
+	var chat models.Chat
+	result := db.GormDB.WithContext(ctx).Unscoped().
+		Select("id, application_id, chat_number").
+		Where("id = ?", data.ChatID).
+		Limit(1).Find(&chat)
+	if result.Error != nil {
+		return fmt.Errorf("loading chat: %w", result.Error)
+	}
+
+	// A chat that is already gone was finished by an earlier delivery
+	if result.RowsAffected > 0 {
+		if err := c.deleteChat(ctx, data, chat.ApplicationID, chat.ChatNumber); err != nil {
+			return err
+		}
+	}
+
+	if standalone {
+		return c.jobs.SetStatus(ctx, data.JobID, jobs.StatusCompleted, nil)
+	}
+
+	var remaining int64
+	if err := db.GormDB.WithContext(ctx).Unscoped().Model(&models.Chat{}).
+		Where("application_id = ?", data.ApplicationID).
+		Count(&remaining).Error; err != nil {
+		return fmt.Errorf("counting remaining chats: %w", err)
+	}
+	if remaining > 0 {
+		return nil
+	}
+	return c.finishApplicationDeletion(ctx, data.JobID, data.ApplicationID)
+}
+
+func (c *consumer) deleteChat(ctx context.Context, data chatDeletionPayload, appID uint, chatNumber int) error {
+	for {
+		result := db.GormDB.WithContext(ctx).Exec("DELETE FROM messages WHERE chat_id = ? LIMIT ?", data.ChatID, deleteBatchSize)
+		if result.Error != nil {
+			return fmt.Errorf("deleting messages: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			if _, err := c.jobs.IncrCounter(ctx, data.JobID, "messages_deleted", result.RowsAffected); err != nil {
+				log.Printf("Error recording deletion progress: %v", err)
+			}
+		}
+		if result.RowsAffected < deleteBatchSize {
+			break
+		}
+	}
+
+	if err := c.deleteDocuments(ctx, "chat_id", data.ChatID); err != nil {
+		return err
+	}
+
+	if err := c.counters.ForgetChat(ctx, data.ChatID); err != nil {
+		return fmt.Errorf("removing message counter: %w", err)
+	}
+	if err := c.resolver.Forget(ctx, appID, chatNumber); err != nil {
+		return fmt.Errorf("removing cached chat: %w", err)
+	}
+	c.counts.forgetChat(ctx, data.ChatID)
+
+	result := db.GormDB.WithContext(ctx).Exec("DELETE FROM chats WHERE id = ?", data.ChatID)
+	if result.Error != nil {
+		return fmt.Errorf("deleting chat: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	if data.ApplicationID == 0 {
+		c.counts.addChats(ctx, appID, -1)
+		return nil
+	}
+	if _, err := c.jobs.IncrCounter(ctx, data.JobID, "chats_deleted", 1); err != nil {
+		log.Printf("Error recording deletion progress: %v", err)
+	}
+	return nil
+}
+
+// finishApplicationDeletion removes what is left of an application once
+// all of its chats are gone. Every step is idempotent, so several chat jobs
+// racing to be last is harmless.
+func (c *consumer) finishApplicationDeletion(ctx context.Context, jobID string, appID uint) error {
+	// Catches documents whose chat row was already gone, e.g. indexed late
+	if err := c.deleteDocuments(ctx, "application_id", appID); err != nil {
+		return err
+	}
+
+	if err := c.counters.ForgetApplication(ctx, appID); err != nil {
+		return fmt.Errorf("removing chat counter: %w", err)
+	}
+	c.counts.forgetApplication(ctx, appID)
+
+	if err := db.GormDB.WithContext(ctx).Exec("DELETE FROM applications WHERE id = ?", appID).Error; err != nil {
+		return fmt.Errorf("deleting application: %w", err)
+	}
+
+	return c.jobs.SetStatus(ctx, jobID, jobs.StatusCompleted, nil)
+}
+
+// deleteDocuments removes every search document whose field matches value.
+func (c *consumer) deleteDocuments(ctx context.Context, field string, value uint) error {
+	query := fmt.Sprintf(`{"query":{"term":{%q:%d}}}`, field, value)
+
+	res, err := c.es.DeleteByQuery([]string{search.Alias}, strings.NewReader(query),
+		c.es.DeleteByQuery.WithContext(ctx),
+		c.es.DeleteByQuery.WithConflicts("proceed"),
+	)
+	if err != nil {
+		return fmt.Errorf("deleting documents from Elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("deleting documents from Elasticsearch: %s", res.String())
+	}
+	return nil
+}
+
+// recordJobError keeps the last failure on the job so its status shows why
+// it is not progressing. While the queue retries qm the job stays running;
+// once the queue gives up on it the job is marked failed. A failed chat of
+// an application deletion fails the whole application's job.
+func (c *consumer) recordJobError(qm *queue.QueuedMessage, jobID string, err *error) {
+	if *err == nil {
+		return
+	}
+
+	status := jobs.StatusRunning
+	if c.queue.GivesUp(qm, *err) {
+		status = jobs.StatusFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if setErr := c.jobs.SetStatus(ctx, jobID, status, *err); setErr != nil {
+		log.Printf("Error recording failure of job %s: %v", jobID, setErr)
+	}
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"bytes"
+	"chat-system/internal/db"
+	"chat-system/internal/db/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// errMessageNotStored is returned while the message an edit refers to has
+// not been written yet, e.g. because its creation job is waiting for a
+// retry. The edit is retried until the message shows up.
+var errMessageNotStored = errors.New("message is not stored yet")
+
+func (c *consumer) processMessageUpdate(ctx context.Context, payload json.RawMessage) error {
+	var data struct {
+		ChatID        uint      `json:"chat_id"`
+		MessageNumber int       `json:"message_number"`
+		Body          string    `json:"body"`
+		EditedAt      time.Time `json:"edited_at"`
+	}
+
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return queue.Permanent(fmt.Errorf("unmarshaling message update payload: %w", err))
+	}
+
+	var message models.Message
+	err := db.GormDB.WithContext(ctx).Unscoped().
+		Where("chat_id = ? AND message_number = ?", data.ChatID, data.MessageNumber).
+		Take(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errMessageNotStored
+	}
+	if err != nil {
+		return fmt.Errorf("loading message: %w", err)
+	}
+
+	// Edits of a message deleted in the meantime have nothing left to change,
+	// and a retried edit must not overwrite one made after it
+	if message.DeletedAt.Valid || (message.EditedAt != nil && message.EditedAt.After(data.EditedAt)) {
+		return nil
+	}
+
+	message.Body = data.Body
+	message.EditedAt = &data.EditedAt
+	if err := db.GormDB.WithContext(ctx).Model(&message).Updates(map[string]interface{}{
+		"body":      data.Body,
+		"edited_at": data.EditedAt,
+	}).Error; err != nil {
+		return fmt.Errorf("updating message: %w", err)
+	}
+
+	return c.indexMessage(ctx, message)
+}
+
+func (c *consumer) processMessageDeletion(ctx context.Context, payload json.RawMessage) error {
+	var data struct {
+		ChatID        uint `json:"chat_id"`
+		MessageNumber int  `json:"message_number"`
+	}
+
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return queue.Permanent(fmt.Errorf("unmarshaling message deletion payload: %w", err))
+	}
+
+	var stored int64
+	if err := db.GormDB.WithContext(ctx).Unscoped().Model(&models.Message{}).
+		Where("chat_id = ? AND message_number = ?", data.ChatID, data.MessageNumber).
+		Count(&stored).Error; err != nil {
+		return fmt.Errorf("loading message: %w", err)
+	}
+	if stored == 0 {
+		return errMessageNotStored
+	}
+
+	// Only the delivery that actually deleted the row adjusts the count
+	result := db.GormDB.WithContext(ctx).
+		Where("chat_id = ? AND message_number = ?", data.ChatID, data.MessageNumber).
+		Delete(&models.Message{})
+	if result.Error != nil {
+		return fmt.Errorf("deleting message: %w", result.Error)
+	}
+	c.counts.addMessages(ctx, map[uint]int64{data.ChatID: -result.RowsAffected})
+
+	return c.deleteMessageDocument(ctx, data.ChatID, data.MessageNumber)
+}
+
+// indexMessage writes the current state of a single message to
+// Elasticsearch, replacing the document if it exists.
+func (c *consumer) indexMessage(ctx context.Context, message models.Message) error {
+	var chat models.Chat
+	if err := db.GormDB.WithContext(ctx).Unscoped().
+		Select("id, application_id, chat_number").
+		First(&chat, message.ChatID).Error; err != nil {
+		return fmt.Errorf("loading chat for indexing: %w", err)
+	}
+
+	document := search.NewDocument(message, chat)
+	body, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("marshaling message for Elasticsearch: %w", err)
+	}
+
+	res, err := c.es.Index(search.Alias, bytes.NewReader(body),
+		c.es.Index.WithContext(ctx),
+		c.es.Index.WithDocumentID(document.ID()),
+	)
+	if err != nil {
+		return fmt.Errorf("indexing message in Elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("indexing message in Elasticsearch: %s", res.String())
+	}
+	return nil
+}
+
+// deleteMessageDocument removes a message from Elasticsearch. A document
+// that is already gone counts as deleted.
+func (c *consumer) deleteMessageDocument(ctx context.Context, chatID uint, messageNumber int) error {
+	res, err := c.es.Delete(search.Alias, search.DocumentID(chatID, messageNumber),
+		c.es.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("deleting message from Elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("deleting message from Elasticsearch: %s", res.String())
+	}
+	return nil
+}
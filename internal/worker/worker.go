@@ -1,166 +1,234 @@
 package worker
 
 import (
-	"bytes"
 	"chat-system/internal/db"
-	"chat-system/internal/db/models"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"chat-system/internal/jobs"
 	"chat-system/internal/queue"
+	"chat-system/internal/reindex"
+	"chat-system/internal/service"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/google/uuid"
 )
 
-type Worker struct {
-	queue *queue.MessageQueue
-	es    *elasticsearch.Client
-	mu    sync.Mutex // Mutex to protect critical sections
-}
-
-func NewWorker(queue *queue.MessageQueue, es *elasticsearch.Client) *Worker {
-	return &Worker{queue: queue, es: es}
-}
+const (
+	heartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 30 * time.Second
+	promoteInterval   = time.Second
+	reapInterval      = 30 * time.Second
+	rebalanceInterval = 5 * time.Second
+	idlePollInterval  = 50 * time.Millisecond
+	batchPollInterval = 5 * time.Millisecond
+)
 
-func (w *Worker) Start(ctx context.Context) {
-	go w.processQueue(ctx)
-	go w.updateCounters(ctx)
+type Worker struct {
+	id        string
+	cfg       Config
+	queue     *queue.MessageQueue
+	es        *elasticsearch.Client
+	counts    *countTracker
+	verifier  *reindex.Verifier
+	consumers []*consumer
+	wg        sync.WaitGroup
 }
 
-func (w *Worker) processQueue(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			result, err := db.Redis.BRPop(ctx, 0, "message_queue").Result()
-			if err != nil {
-				log.Printf("Error popping from queue: %v", err)
-				continue
-			}
-
-			var qm queue.QueuedMessage
-			if err := json.Unmarshal([]byte(result[1]), &qm); err != nil {
-				log.Printf("Error unmarshaling message: %v", err)
-				continue
-			}
-
-			switch qm.Type {
-			case "chat_creation":
-				w.processChatCreation(ctx, qm.Payload)
-			case "message_creation":
-				w.processMessageCreation(ctx, qm.Payload)
-			}
-		}
+func NewWorker(queue *queue.MessageQueue, es *elasticsearch.Client, cfg Config) *Worker {
+	w := &Worker{
+		id:       uuid.New().String(),
+		cfg:      cfg,
+		queue:    queue,
+		es:       es,
+		counts:   newCountTracker(db.Redis),
+		verifier: reindex.NewVerifier(db.GormDB, es, db.Redis),
+	}
+	jobStore := jobs.NewStore(db.Redis)
+	counters := service.NewCounterService(db.GormDB, db.Redis)
+	resolver := service.NewChatResolver(db.GormDB, db.Redis, service.NewTokenResolver(db.GormDB, db.Redis))
+	for i := 0; i < cfg.Concurrency; i++ {
+		w.consumers = append(w.consumers, &consumer{
+			id:       fmt.Sprintf("%s-%d", w.id, i),
+			cfg:      cfg,
+			queue:    queue,
+			es:       es,
+			counts:   w.counts,
+			jobs:     jobStore,
+			counters: counters,
+			resolver: resolver,
+		})
 	}
+	return w
 }
 
-func (w *Worker) processChatCreation(ctx context.Context, payload json.RawMessage) {
-	var data struct {
-		AppID      uint `json:"app_id"`
-		ChatNumber int  `json:"chat_number"`
+func (w *Worker) Start(ctx context.Context) {
+	if n, err := w.queue.MigrateLegacy(ctx); err != nil {
+		log.Printf("Error migrating legacy queue: %v", err)
+	} else if n > 0 {
+		log.Printf("Moved %d jobs from the legacy queue onto shard 0", n)
 	}
 
-	if err := json.Unmarshal(payload, &data); err != nil {
-		log.Printf("Error unmarshaling chat creation payload: %v", err)
-		return
+	// Register before consuming so the reaper never sees our jobs as orphaned
+	for _, c := range w.consumers {
+		if err := w.queue.Heartbeat(ctx, c.id, heartbeatTTL); err != nil {
+			log.Printf("Error registering consumer %s: %v", c.id, err)
+		}
 	}
 
-	w.mu.Lock() // Lock before creating chat
-	defer w.mu.Unlock()
-
-	chat := &models.Chat{
-		ApplicationID: data.AppID,
-		ChatNumber:    data.ChatNumber,
+	w.goRun(func() { w.heartbeat(ctx) })
+	w.goRun(func() { w.maintainQueue(ctx) })
+	for _, c := range w.consumers {
+		w.goRun(func() { c.run(ctx) })
 	}
-
-	if err := db.GormDB.Create(chat).Error; err != nil {
-		log.Printf("Error creating chat: %v", err)
-		return
+	w.goRun(func() { w.updateCounters(ctx) })
+	if w.cfg.VerifyInterval > 0 {
+		w.goRun(func() { w.verifyIndex(ctx) })
 	}
 }
 
-func (w *Worker) processMessageCreation(ctx context.Context, payload json.RawMessage) {
-	var data struct {
-		ChatID        uint   `json:"chat_id"`
-		MessageNumber int    `json:"message_number"`
-		Body          string `json:"body"`
-	}
+func (w *Worker) goRun(fn func()) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn()
+	}()
+}
 
-	if err := json.Unmarshal(payload, &data); err != nil {
-		log.Printf("Error unmarshaling message creation payload: %v", err)
-		return
+// Shutdown waits for the worker to stop after the context passed to Start
+// was cancelled. Consumers finish the batch they are working on, hand back
+// their shards and exit. It returns ctx.Err() if that takes longer than ctx
+// allows.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	w.mu.Lock() // Lock before creating chat
-	defer w.mu.Unlock()
-
-	message := &models.Message{
-		ChatID:        data.ChatID,
-		MessageNumber: data.MessageNumber,
-		Body:          data.Body,
+// heartbeat keeps every consumer registered and renews its shard leases.
+// Leases that were lost, e.g. after a long pause, are dropped so the
+// consumer stops taking jobs from those shards.
+func (w *Worker) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range w.consumers {
+				if err := w.queue.Heartbeat(ctx, c.id, heartbeatTTL); err != nil {
+					log.Printf("Error sending consumer heartbeat: %v", err)
+					continue
+				}
+
+				lost, err := w.queue.RenewShards(ctx, c.id, c.ownedShards(), heartbeatTTL)
+				if err != nil {
+					log.Printf("Error renewing shard leases: %v", err)
+				}
+				if len(lost) > 0 {
+					log.Printf("Consumer %s lost shards %v", c.id, lost)
+					c.dropShards(lost)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
+}
 
-	if err := db.GormDB.Create(message).Error; err != nil {
-		log.Printf("Error creating message: %v", err)
-		return
+// maintainQueue promotes due retries and recovers jobs left behind by
+// crashed workers.
+func (w *Worker) maintainQueue(ctx context.Context) {
+	promote := time.NewTicker(promoteInterval)
+	defer promote.Stop()
+	reap := time.NewTicker(reapInterval)
+	defer reap.Stop()
+	for {
+		select {
+		case <-promote.C:
+			if _, err := w.queue.PromoteDelayed(ctx); err != nil {
+				log.Printf("Error promoting delayed jobs: %v", err)
+			}
+		case <-reap.C:
+			n, err := w.queue.ReapOrphans(ctx)
+			if err != nil {
+				log.Printf("Error reaping orphaned jobs: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Re-queued %d orphaned jobs", n)
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
+}
 
-	// Index the message in Elasticsearch
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling message for Elasticsearch: %v", err)
-		return
+// updateCounters flushes count deltas on a short interval and runs the
+// full recount on the repair schedule. Pending deltas are flushed once more
+// on shutdown.
+func (w *Worker) updateCounters(ctx context.Context) {
+	flush := time.NewTicker(w.cfg.CountFlushInterval)
+	defer flush.Stop()
+
+	var recount <-chan time.Time
+	if w.cfg.RecountInterval > 0 {
+		ticker := time.NewTicker(w.cfg.RecountInterval)
+		defer ticker.Stop()
+		recount = ticker.C
 	}
 
-	_, err = w.es.Index(
-		"messages", // Index name
-		bytes.NewReader(messageJSON),
-		w.es.Index.WithDocumentID(fmt.Sprintf("%d-%d", message.ChatID, message.MessageNumber)),
-		w.es.Index.WithRefresh("true"), // Refresh the index
-	)
-	if err != nil {
-		log.Printf("Error indexing message in Elasticsearch: %v", err)
-		return
-	}
-	if err != nil {
-		log.Printf("Error indexing message in Elasticsearch: %v", err)
-		return
+	for {
+		select {
+		case <-flush.C:
+			w.flushCounts(ctx)
+		case <-recount:
+			if err := w.counts.recountAll(ctx); err != nil {
+				log.Printf("Error recounting chats and messages: %v", err)
+			}
+		case <-ctx.Done():
+			w.flushCounts(context.WithoutCancel(ctx))
+			return
+		}
 	}
 }
 
-func (w *Worker) updateCounters(ctx context.Context) {
-	ticker := time.NewTicker(15 * time.Minute)
+// verifyIndex periodically compares the search index with MySQL. Every
+// worker schedules the check, but only one runs it at a time.
+func (w *Worker) verifyIndex(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.VerifyInterval)
+	defer ticker.Stop()
+
+	opts := reindex.VerifyOptions{SampleSize: w.cfg.VerifySampleSize, Repair: w.cfg.VerifyRepair}
 	for {
 		select {
 		case <-ticker.C:
-			w.syncCounters(ctx)
+			_, err := w.verifier.Verify(ctx, opts)
+			if err != nil && ctx.Err() == nil && !errors.Is(err, reindex.ErrVerifying) && !errors.Is(err, reindex.ErrRunning) {
+				log.Printf("Error verifying the search index: %v", err)
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (w *Worker) syncCounters(ctx context.Context) {
-	// Update application chat counts
-	db.GormDB.Exec(`
-		UPDATE applications a
-		SET chats_count = (
-			SELECT COUNT(*) FROM chats
-			WHERE application_id = a.id
-		)
-	`)
-
-	// Update chat message counts
-	db.GormDB.Exec(`
-		UPDATE chats c
-		SET messages_count = (
-			SELECT COUNT(*) FROM messages
-			WHERE chat_id = c.id
-		)
-	`)
+func (w *Worker) flushCounts(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, countFlushTimeout)
+	defer cancel()
+
+	if err := w.counts.flush(ctx); err != nil {
+		log.Printf("Error flushing count deltas: %v", err)
+	}
 }